@@ -0,0 +1,215 @@
+// loopbench 在本机回环地址上对比逐个生成请求和预先构造请求（快速路径）时 wrkx 能达到的最大QPS。
+// 默认在进程内启动一个立即返回的HTTP服务器，此时测到的是客户端和服务端共用CPU时的上限；
+// 通过 --listen 在另一个进程中单独启动服务器，再用 --url 指向它，可以把客户端和服务端绑定到不同的CPU上。
+// 通过 --baseline 指定另一个版本（如加入快速路径之前的版本）的 wrkx 可执行文件，在同样的条件下交替压测，
+// 与之对比每秒请求数和每个请求的CPU时间。
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/panzhongxian/wrkx/internal/gen"
+	"github.com/panzhongxian/wrkx/internal/worker"
+)
+
+// result 一轮压测的结果
+type result struct {
+	name        string
+	requests    int64
+	failed      int64
+	qps         float64
+	allocsPerOp float64 // 为负数时表示无法统计
+	bytesPerOp  float64
+	cpuPerOp    time.Duration // 为0时表示无法统计
+}
+
+func main() {
+	url := flag.String("url", "", "压测的URL，默认在进程内启动一个回环地址上的服务器")
+	method := flag.String("method", "POST", "HTTP请求方法")
+	request := flag.String("request", `{"hello":"world"}`, "固定的请求体")
+	headers := flag.String("header", "X-Bench:loopbench", "额外的HTTP头部，格式为key1:value1,key2:value2")
+	concurrency := flag.Int("concurrency", 64, "并发数")
+	duration := flag.Duration("duration", 5*time.Second, "每轮压测的持续时间")
+	rounds := flag.Int("rounds", 1, "两种方式各压测的轮数，交替进行")
+	listen := flag.String("listen", "", "只启动服务器并监听该地址，如 127.0.0.1:9000，不进行压测")
+	baseline := flag.String("baseline", "", "作为对比基准的另一个版本的 wrkx 可执行文件，需要同时指定 --url")
+	flag.Parse()
+
+	if *listen != "" {
+		fmt.Printf("服务器监听 %s\n", *listen)
+		if err := http.ListenAndServe(*listen, handler); err != nil {
+			fmt.Printf("错误：%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *baseline != "" && *url == "" {
+		// 进程内的服务器会计入本进程的CPU时间，与基准版本的CPU时间没有可比性
+		fmt.Println("错误：指定 --baseline 时需要用 --listen 单独启动服务器，并通过 --url 指向它")
+		os.Exit(1)
+	}
+
+	target := *url
+	if target == "" {
+		addr, err := serve()
+		if err != nil {
+			fmt.Printf("错误：启动服务器失败: %v\n", err)
+			os.Exit(1)
+		}
+		target = "http://" + addr + "/"
+	}
+
+	fmt.Printf("URL: %s, 并发数: %d, 每轮 %v, GOMAXPROCS: %d\n\n", target, *concurrency, *duration, runtime.GOMAXPROCS(0))
+	var base, slow, fast []result
+	for i := 0; i < *rounds; i++ {
+		if *baseline != "" {
+			r, err := runBinary("基准版本", *baseline, target, *method, *request, *headers, *concurrency, *duration)
+			if err != nil {
+				fmt.Printf("错误：运行基准版本失败: %v\n", err)
+				os.Exit(1)
+			}
+			base = append(base, r)
+		}
+		slow = append(slow, run("逐个生成请求", false, target, *method, *request, *headers, *concurrency, *duration))
+		fast = append(fast, run("预先构造请求", true, target, *method, *request, *headers, *concurrency, *duration))
+	}
+
+	fmt.Printf("%-12s %12s %8s %12s %14s %14s %14s\n", "方式", "请求数", "失败", "每秒请求数", "每请求分配次数", "每请求分配字节", "每请求CPU时间")
+	for _, r := range append(append(base, slow...), fast...) {
+		allocs, bytes, cpu := "-", "-", "-"
+		if r.allocsPerOp >= 0 {
+			allocs, bytes = strconv.FormatFloat(r.allocsPerOp, 'f', 1, 64), strconv.FormatFloat(r.bytesPerOp, 'f', 0, 64)
+		}
+		if r.cpuPerOp > 0 {
+			cpu = r.cpuPerOp.Round(100 * time.Nanosecond).String()
+		}
+		fmt.Printf("%-12s %12d %8d %12.0f %14s %14s %14s\n", r.name, r.requests, r.failed, r.qps, allocs, bytes, cpu)
+	}
+	fmt.Println("\n每秒请求数取各轮最高，每请求CPU时间取各轮最低:")
+	if len(base) > 0 {
+		compare("基准版本", "预先构造请求", base, fast)
+	}
+	compare("逐个生成请求", "预先构造请求", slow, fast)
+}
+
+// compare 打印两种方式的每秒请求数和每请求CPU时间的变化
+func compare(beforeName, afterName string, before, after []result) {
+	b, a := best(before), best(after)
+	fmt.Printf("  %s -> %s: 每秒请求数 %.0f -> %.0f (%+.1f%%)", beforeName, afterName, b, a, (a-b)*100/b)
+	if bc, ac := minCPU(before), minCPU(after); bc > 0 && ac > 0 {
+		fmt.Printf(", 每请求CPU时间 %v -> %v (%+.1f%%)", bc, ac, float64(ac-bc)*100/float64(bc))
+	}
+	fmt.Println()
+}
+
+// handler 读完请求体后立即返回 ok
+var handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+	w.Write([]byte("ok"))
+})
+
+// serve 在回环地址的随机端口上启动服务器，返回监听地址
+func serve() (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	go http.Serve(ln, handler)
+	return ln.Addr().String(), nil
+}
+
+// run 压测一轮，fastPath 表示是否启用快速路径。分配次数包括进程内服务器的分配。
+func run(name string, fastPath bool, url, method, request, headers string, concurrency int, duration time.Duration) result {
+	w := worker.NewWorker(url, concurrency, duration, 5*time.Second, 0, gen.NewSimpleRequestGenerator(request), false, method, headers, "")
+	if w == nil {
+		os.Exit(1)
+	}
+	w.SetFastPath(fastPath)
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	cpuBefore, cpuOK := worker.ProcessCPUTime()
+	w.Start(context.Background())
+	cpuAfter, _ := worker.ProcessCPUTime()
+	runtime.ReadMemStats(&after)
+
+	stats := w.GetStats()
+	r := result{name: name, requests: stats.TotalRequests, failed: stats.FailedRequests, qps: stats.RequestsPerSec}
+	if n := stats.TotalRequests + stats.FailedRequests; n > 0 {
+		r.allocsPerOp = float64(after.Mallocs-before.Mallocs) / float64(n)
+		r.bytesPerOp = float64(after.TotalAlloc-before.TotalAlloc) / float64(n)
+		if cpuOK {
+			r.cpuPerOp = (cpuAfter - cpuBefore) / time.Duration(n)
+		}
+	}
+	return r
+}
+
+var (
+	totalRequestsPattern  = regexp.MustCompile(`总请求数: (\d+)`)
+	failedRequestsPattern = regexp.MustCompile(`失败请求数: (\d+)`)
+	requestsPerSecPattern = regexp.MustCompile(`每秒请求数: ([\d.]+)`)
+)
+
+// runBinary 用另一个版本的 wrkx 可执行文件以相同的参数压测一轮，从它的输出中读取请求数和每秒请求数。
+// 无法统计其他进程的内存分配，CPU时间包括进程启动和退出的开销。
+func runBinary(name, path, url, method, request, headers string, concurrency int, duration time.Duration) (result, error) {
+	seconds := max(1, int(duration.Round(time.Second)/time.Second))
+	cmd := exec.Command(path, "--url", url, "--method", method, "--request", request, "--header", headers,
+		"--concurrency", strconv.Itoa(concurrency), "--duration", strconv.Itoa(seconds))
+	out, err := cmd.Output()
+	if err != nil {
+		return result{}, err
+	}
+
+	r := result{name: name, allocsPerOp: -1}
+	total := totalRequestsPattern.FindSubmatch(out)
+	qps := requestsPerSecPattern.FindSubmatch(out)
+	if total == nil || qps == nil {
+		return result{}, fmt.Errorf("%s 的输出中没有总请求数和每秒请求数", path)
+	}
+	r.requests, _ = strconv.ParseInt(string(total[1]), 10, 64)
+	r.qps, _ = strconv.ParseFloat(string(qps[1]), 64)
+	if failed := failedRequestsPattern.FindSubmatch(out); failed != nil {
+		r.failed, _ = strconv.ParseInt(string(failed[1]), 10, 64)
+	}
+	if n := r.requests + r.failed; n > 0 {
+		r.cpuPerOp = (cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()) / time.Duration(n)
+	}
+	return r, nil
+}
+
+// minCPU 返回各轮中最低的每请求CPU时间，无法统计时返回0
+func minCPU(results []result) time.Duration {
+	var min time.Duration
+	for _, r := range results {
+		if r.cpuPerOp > 0 && (min == 0 || r.cpuPerOp < min) {
+			min = r.cpuPerOp
+		}
+	}
+	return min
+}
+
+// best 返回各轮中最高的每秒请求数
+func best(results []result) float64 {
+	var max float64
+	for _, r := range results {
+		if r.qps > max {
+			max = r.qps
+		}
+	}
+	return max
+}
@@ -1,16 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"math/rand"
 	"net"
 	"os"
-	"path/filepath"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/panzhongxian/wrkx/internal/gen"
+	"github.com/panzhongxian/wrkx/internal/report"
+	"github.com/panzhongxian/wrkx/internal/threshold"
 	"github.com/panzhongxian/wrkx/internal/worker"
 )
 
@@ -51,192 +52,210 @@ func isIPAvailable(ip string) bool {
 	return false
 }
 
+// 进程退出码，便于在CI中根据压测结果判断是否通过
+const (
+	exitOK              = 0   // 压测完成且所有阈值通过
+	exitUsageError      = 1   // 参数错误
+	exitRunError        = 2   // 压测无法执行，如创建请求生成器或统计文件失败
+	exitThresholdFailed = 3   // 压测完成但有阈值未通过
+	exitClientSaturated = 4   // 压测客户端已饱和，没有产生目标负载，结果无效
+	exitInterrupted     = 5   // 压测被中断，结果只包含中断之前的部分
+	exitAborted         = 130 // 压测被中断后再次收到信号，立即退出，不输出结果
+)
+
+// signalContext 返回收到第一个 SIGINT 或 SIGTERM 时取消的 context，压测停止并输出已有的结果；
+// 收到第二个信号时立即退出。调用返回的函数停止接收信号。
+func signalContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigs:
+		case <-done:
+			return
+		}
+		fmt.Printf("\n收到中断信号，停止压测并等待正在发送的请求完成，再次按 Ctrl-C 立即退出\n")
+		cancel()
+		select {
+		case <-sigs:
+			fmt.Printf("\n再次收到中断信号，立即退出\n")
+			os.Exit(exitAborted)
+		case <-done:
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sigs)
+		close(done)
+		cancel()
+	}
+}
+
 func main() {
-	var (
-		url               string
-		concurrency       int
-		duration          int
-		timeout           float64
-		qps               int
-		maxWorkers        int
-		enableSecondStats bool
-		file              string
-		reqTemplate       string
-		request           string
-		method            string
-		headers           string
-		srcIP             string
-	)
-
-	flag.StringVar(&url, "url", "http://localhost:8080/delay", "测试目标URL")
-	flag.IntVar(&concurrency, "concurrency", 0, "并发数（与qps互斥）")
-	flag.IntVar(&duration, "duration", 30, "测试持续时间(秒)")
-	flag.Float64Var(&timeout, "timeout", 5, "请求超时时间(秒)")
-	flag.IntVar(&qps, "qps", 0, "每秒请求数（与concurrency互斥）")
-	flag.IntVar(&maxWorkers, "max-workers", 2000, "QPS模式下的最大并发数")
-	flag.BoolVar(&enableSecondStats, "enable-second-stats", false, "是否记录每秒的统计信息（不需要指定值，使用该参数即表示启用）")
-	flag.StringVar(&file, "file", "", "输入文件路径，如果指定则使用文件内容作为请求体")
-	flag.StringVar(&reqTemplate, "req-template", "", "请求模板，用于从CSV文件生成请求体")
-	flag.StringVar(&request, "request", "", "请求体字符串，如果指定则file和req-template必须为空")
-	flag.StringVar(&method, "method", "POST", "HTTP请求方法")
-	flag.StringVar(&headers, "header", "", "额外的HTTP头部，格式为key1:value1,key2:value2")
-	flag.StringVar(&srcIP, "src-ip", "", "指定源IP地址，用于绑定网络连接")
-
-	// 检查是否有未定义的参数
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "用法: %s [选项]\n\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "选项:\n")
-		flag.PrintDefaults()
-		fmt.Fprintf(os.Stderr, "\n注意：布尔类型参数（如 --enable-second-stats）不需要指定值，直接使用参数名即可\n")
-	}
-
-	// 检查是否有未定义的参数
 	args := os.Args[1:]
-	for i := 0; i < len(args); i++ {
-		arg := args[i]
-		if strings.HasPrefix(arg, "--") {
-			// 检查是否是布尔参数
-			if arg == "--enable-second-stats" {
-				continue
-			}
-			// 检查下一个参数是否是值
-			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "--") {
-				i++ // 跳过值
-				continue
-			}
-			// 检查是否是等号形式
-			if strings.Contains(arg, "=") {
-				continue
-			}
-			fmt.Printf("错误：未知参数 %s\n", arg)
-			flag.Usage()
-			os.Exit(1)
+	if len(args) > 0 {
+		switch args[0] {
+		case "search":
+			os.Exit(runSearch(args[1:]))
+		case "run":
+			os.Exit(runScenario(args[1:]))
 		}
 	}
+	os.Exit(runLoad(args))
+}
+
+// runLoad 按命令行指定的并发数、QPS或负载阶段执行一次压测，返回进程退出码
+func runLoad(args []string) int {
+	var opts options
+	fs := flag.NewFlagSet("wrkx", flag.ContinueOnError)
+	opts.register(fs)
+	if err := parseArgs(fs, args, fmt.Sprintf("%s [选项]\n      %s run 场景文件 [选项]\n      %s search [选项]", os.Args[0], os.Args[0], os.Args[0])); err != nil {
+		return parseExitCode(err)
+	}
+	return executeLoad(&opts)
+}
 
-	flag.Parse()
+// executeLoad 校验参数并执行一次压测，返回进程退出码
+func executeLoad(opts *options) int {
+	if err := opts.parseLoadModel(); err != nil {
+		fmt.Printf("错误：%v\n", err)
+		return exitUsageError
+	}
+	if err := opts.parseChecks(); err != nil {
+		fmt.Printf("错误：%v\n", err)
+		return exitUsageError
+	}
+	if err := opts.parseTransport(); err != nil {
+		fmt.Printf("错误：%v\n", err)
+		return exitUsageError
+	}
+	if err := opts.parseOutputs(); err != nil {
+		fmt.Printf("错误：%v\n", err)
+		return exitUsageError
+	}
+
+	// 解析阈值表达式
+	var thresholds []*threshold.Threshold
+	for _, expr := range opts.thresholds {
+		t, err := threshold.Parse(expr)
+		if err != nil {
+			fmt.Printf("错误：%v\n", err)
+			return exitUsageError
+		}
+		thresholds = append(thresholds, t)
+	}
 
 	// 打印所有参数值，帮助调试
 	fmt.Printf("参数值:\n")
-	fmt.Printf("  URL: %s\n", url)
-	fmt.Printf("  请求方法: %s\n", method)
-	if headers != "" {
-		fmt.Printf("  额外头部: %s\n", headers)
-	}
-	if concurrency > 0 {
-		fmt.Printf("  模式: 并发模式, 并发数: %d\n", concurrency)
+	opts.printRequest()
+	if opts.concurrency > 0 {
+		fmt.Printf("  模式: 并发模式, 并发数: %d\n", opts.concurrency)
+	} else if len(opts.stages) > 0 {
+		fmt.Printf("  模式: 分阶段QPS模式, 过渡方式: %s\n", opts.mode)
+		for i, stage := range opts.stages {
+			fmt.Printf("    阶段%d: %v -> QPS %d\n", i+1, stage.Duration, stage.Target)
+		}
 	} else {
-		fmt.Printf("  模式: QPS模式, QPS: %d\n", qps)
+		fmt.Printf("  模式: QPS模式, QPS: %d\n", opts.qps)
 	}
-	fmt.Printf("  持续时间: %d秒\n", duration)
-	fmt.Printf("  超时时间: %.3f秒\n", timeout)
-	fmt.Printf("  最大并发数: %d\n", maxWorkers)
-	fmt.Printf("  每秒统计: %v\n", enableSecondStats)
-	if srcIP != "" {
-		fmt.Printf("  源IP地址: %s\n", srcIP)
+	if opts.concurrency == 0 {
+		fmt.Printf("  到达过程: %s\n", opts.arrival)
 	}
-
-	if request != "" {
-		fmt.Printf("  请求体: %s\n", request)
-	} else if reqTemplate != "" {
-		fmt.Printf("  请求模板: %s\n", reqTemplate)
-		fmt.Printf("  文件路径: %s\n", file)
-	} else if file != "" {
-		fmt.Printf("  文件路径: %s\n", file)
+	fmt.Printf("  持续时间: %d秒\n", opts.duration)
+	opts.printCommon()
+	if len(opts.thresholds) > 0 {
+		fmt.Printf("  阈值: %s\n", opts.thresholds.String())
 	}
 	fmt.Println()
 
 	// 验证参数
-	if concurrency > 0 && qps > 0 {
-		fmt.Println("错误：concurrency 和 qps 参数不能同时使用")
-		return
+	if err := opts.validateLoadMode(); err != nil {
+		fmt.Printf("错误：%v\n", err)
+		return exitUsageError
 	}
-	if concurrency == 0 && qps == 0 {
-		fmt.Println("错误：必须指定 concurrency 或 qps 参数")
-		return
-	}
-	if qps > 0 && maxWorkers <= 0 {
-		fmt.Println("错误：QPS模式下必须指定大于0的max-workers参数")
-		return
+	if err := opts.validateCommon(); err != nil {
+		fmt.Printf("错误：%v\n", err)
+		return exitUsageError
 	}
 
-	// 验证request参数
-	if request != "" && (file != "" || reqTemplate != "") {
-		fmt.Println("错误：使用 --request 参数时，--file 和 --req-template 必须为空")
-		return
+	if err := opts.startMetrics(); err != nil {
+		fmt.Printf("错误：%v\n", err)
+		return exitUsageError
 	}
+	defer opts.stopMetrics()
 
-	// 验证src-ip参数
-	if srcIP != "" {
-		if net.ParseIP(srcIP) == nil {
-			fmt.Printf("错误：无效的IP地址 %s\n", srcIP)
-			return
-		}
-		// 检查IP地址是否可用
-		if !isIPAvailable(srcIP) {
-			fmt.Printf("错误：IP地址 %s 不可用或不存在于本机\n", srcIP)
-			return
-		}
+	// 创建请求生成器
+	reqGenerator, err := opts.newGenerator()
+	if err != nil {
+		fmt.Println(err)
+		return exitRunError
 	}
 
-	// 验证文件相关参数
-	if reqTemplate != "" && file == "" {
-		fmt.Println("错误：使用 --req-template 时必须指定 --file 参数")
-		return
+	w := opts.newWorker(reqGenerator, opts.qps, time.Duration(opts.duration)*time.Second, opts.enableSecondStats)
+	if w == nil {
+		return exitRunError
 	}
-	if file != "" {
-		// Split files by comma and validate each one
-		files := strings.Split(file, ",")
-		for _, f := range files {
-			f = strings.TrimSpace(f)
-			if _, err := os.Stat(f); os.IsNotExist(err) {
-				fmt.Printf("错误：文件 %s 不存在\n", f)
-				return
-			}
-			// If template is specified, validate CSV format for each file
-			if reqTemplate != "" {
-				ext := strings.ToLower(filepath.Ext(f))
-				if ext != ".csv" {
-					fmt.Printf("错误：使用 --req-template 时，文件 %s 必须是CSV格式\n", f)
-					return
-				}
-			}
+	if len(opts.endpoints) > 0 {
+		endpoints, err := opts.newEndpoints(opts.endpoints, reqGenerator)
+		if err != nil {
+			fmt.Println(err)
+			return exitRunError
+		}
+		w.SetEndpoints(endpoints)
+	}
+	if len(opts.flow) > 0 {
+		steps, err := opts.newEndpoints(opts.flow, reqGenerator)
+		if err != nil {
+			fmt.Println(err)
+			return exitRunError
 		}
+		w.SetFlow(steps)
 	}
 
-	// 初始化随机数生成器
-	rand.Seed(time.Now().UnixNano())
+	fmt.Printf("开始压测...\n")
 
-	// 创建请求生成器
-	var reqGenerator gen.RequestGenerator
-	var err error
-	if file != "" {
-		if reqTemplate != "" {
-			// 使用模板生成器
-			reqGenerator, err = gen.NewTplGenerator(file, reqTemplate)
-			if err != nil {
-				fmt.Printf("创建模板生成器失败: %v\n", err)
-				return
+	ctx, stop := signalContext()
+	defer stop()
+	w.Start(ctx)
+	stats := w.GetStats()
+	stats.PrintStats()
+	worker.PrintEndpointStats(w.GetEndpointStats())
+	worker.PrintStepStats(w.GetStepStats())
+
+	// 检查阈值
+	results := threshold.Evaluate(thresholds, stats)
+	threshold.PrintResults(results)
+	stats.Client.PrintWarning()
+
+	// 输出报告
+	if len(opts.outputPaths) > 0 {
+		r := report.New(opts.reportConfig(), stats, w.GetEndpointStats(), w.GetStepStats(), w.GetSecondStats(), results)
+		if path, ok := opts.outputPaths["json"]; ok {
+			if err := r.WriteJSON(path); err != nil {
+				fmt.Printf("错误：%v\n", err)
+				return exitRunError
 			}
-		} else {
-			// 使用文件生成器
-			reqGenerator, err = gen.NewFileGenerator(file)
-			if err != nil {
-				fmt.Printf("创建文件生成器失败: %v\n", err)
-				return
+			fmt.Printf("\nJSON报告已写入: %s\n", path)
+		}
+		if path, ok := opts.outputPaths["html"]; ok {
+			if err := r.WriteHTML(path); err != nil {
+				fmt.Printf("错误：%v\n", err)
+				return exitRunError
 			}
+			fmt.Printf("\nHTML报告已写入: %s\n", path)
 		}
-	} else if request != "" {
-		reqGenerator = gen.NewSimpleRequestGenerator(request)
-	} else {
-		reqGenerator = gen.NewCustomRequestGenerator()
 	}
 
-	w := worker.NewWorker(url, concurrency, time.Duration(duration)*time.Second, time.Duration(timeout*1000)*time.Millisecond, qps, reqGenerator, enableSecondStats, method, headers, srcIP)
-	w.SetMaxWorkers(int32(maxWorkers))
-
-	fmt.Printf("开始压测...\n")
-
-	w.Start()
-	w.GetStats().PrintStats()
+	// 被中断或客户端饱和时阈值检查的结果也不可信，优先报告结果不完整或无效
+	if stats.Interrupted {
+		return exitInterrupted
+	}
+	if stats.Client.Saturated() {
+		return exitClientSaturated
+	}
+	if !threshold.AllPassed(results) {
+		return exitThresholdFailed
+	}
+	return exitOK
 }
@@ -0,0 +1,766 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/panzhongxian/wrkx/internal/gen"
+	"github.com/panzhongxian/wrkx/internal/metrics"
+	"github.com/panzhongxian/wrkx/internal/report"
+	"github.com/panzhongxian/wrkx/internal/worker"
+)
+
+// options 压测的命令行参数，压测模式和搜索模式共用
+type options struct {
+	url               string
+	concurrency       int
+	duration          int
+	timeout           float64
+	qps               int
+	maxWorkers        int
+	enableSecondStats bool
+	file              string
+	reqTemplate       string
+	request           string
+	method            string
+	headers           string
+	headerMap         map[string]string // 由场景文件设置，设置后代替 headers，头部的值可以包含逗号
+	srcIP             string
+	stagesSpec        string
+	stagesFile        string
+	stageMode         string
+	arrivalProcess    string
+	burstSize         int
+	burstPeriod       time.Duration
+	thresholds        stringList
+	outputs           stringList
+	metricsAddr       string
+	successStatusSpec string
+	assertExprs       stringList
+	cookies           bool
+	vuPool            bool
+	http2             bool
+	h2c               bool
+	h2Conns           int
+	h2Streams         int
+	tlsCert           string
+	tlsKey            string
+	tlsCA             string
+	tlsServerName     string
+	tlsInsecure       bool
+	tlsMinVersion     string
+	tlsMaxVersion     string
+	tlsCiphers        string
+	tlsResumption     bool
+	connReuseSpec     string
+	gracePeriod       time.Duration
+	noFastPath        bool
+	endpoints         []endpointSpec // 由场景文件设置，设置后按权重在多个接口之间分配请求
+	flow              []endpointSpec // 由场景文件设置，设置后每次迭代依次发送各步骤
+
+	// 以下字段由 parseLoadModel 解析得到
+	stages  []worker.Stage
+	mode    worker.StageMode
+	arrival worker.ArrivalConfig
+	// 以下字段由 parseChecks 解析得到
+	successStatus worker.StatusSet
+	assertions    []*worker.Assertion
+	// 以下字段由 parseTransport 解析得到
+	tlsConfig *tls.Config
+	connReuse worker.ConnReuseConfig
+	// 以下字段由 parseOutputs 解析得到，key 为报告类型，value 为输出路径
+	outputPaths map[string]string
+	// 指定了 --metrics-addr 时由 startMetrics 启动
+	metrics *metrics.Server
+}
+
+// outputTypes 支持的报告类型
+var outputTypes = map[string]bool{
+	"json": true,
+	"html": true,
+}
+
+// register 注册所有参数
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.url, "url", "http://localhost:8080/delay", "测试目标URL")
+	fs.IntVar(&o.concurrency, "concurrency", 0, "并发数（与qps互斥）")
+	fs.IntVar(&o.duration, "duration", 30, "测试持续时间(秒)")
+	fs.Float64Var(&o.timeout, "timeout", 5, "请求超时时间(秒)")
+	fs.IntVar(&o.qps, "qps", 0, "每秒请求数（与concurrency互斥）")
+	fs.IntVar(&o.maxWorkers, "max-workers", 2000, "QPS模式下的最大并发数")
+	fs.BoolVar(&o.enableSecondStats, "enable-second-stats", false, "是否记录每秒的统计信息（不需要指定值，使用该参数即表示启用）")
+	fs.StringVar(&o.file, "file", "", "输入文件路径，如果指定则使用文件内容作为请求体")
+	fs.StringVar(&o.reqTemplate, "req-template", "", "请求模板，用于从CSV文件生成请求体")
+	fs.StringVar(&o.request, "request", "", "请求体字符串，如果指定则file和req-template必须为空")
+	fs.StringVar(&o.method, "method", "POST", "HTTP请求方法")
+	fs.StringVar(&o.headers, "header", "", "额外的HTTP头部，格式为key1:value1,key2:value2")
+	fs.StringVar(&o.srcIP, "src-ip", "", "指定源IP地址，用于绑定网络连接")
+	fs.StringVar(&o.stagesSpec, "stages", "", "分阶段的QPS负载曲线，格式为 时长:QPS,时长:QPS，例如 30s:100,60s:1000,30s:0（与qps互斥）")
+	fs.StringVar(&o.stagesFile, "stages-file", "", "从文件读取分阶段的QPS负载曲线，每行一个 时长:QPS")
+	fs.StringVar(&o.stageMode, "stage-mode", "ramp", "阶段之间的过渡方式：ramp 线性过渡，step 直接切换")
+	fs.StringVar(&o.arrivalProcess, "arrival", "uniform", "QPS模式下请求的到达过程：uniform 均匀间隔，poisson 泊松到达，burst 成批到达")
+	fs.IntVar(&o.burstSize, "burst-size", 0, "burst到达过程下每批的请求数，批间隔由目标QPS推算")
+	fs.DurationVar(&o.burstPeriod, "burst-period", 0, "burst到达过程下批与批之间的间隔（如 1s），每批请求数由目标QPS推算")
+	fs.Var(&o.outputs, "output", "压测报告的输出，格式为 类型=路径，如 json=result.json、html=report.html，可多次指定")
+	fs.StringVar(&o.metricsAddr, "metrics-addr", "", "压测期间以Prometheus格式导出指标的监听地址，如 :9090，指标路径为 /metrics")
+	fs.StringVar(&o.successStatusSpec, "success-status", "200", "视为成功的HTTP状态码，支持范围，如 200-299,304")
+	fs.Var(&o.assertExprs, "assert", "对每个响应检查的断言，如 'header:Content-Type=application/json'、'body-contains:ok'、'body-regex:^\\{'、'json:code=0'，可多次指定")
+	fs.BoolVar(&o.cookies, "cookies", false, "每个虚拟用户有自己的Cookie jar，保存响应设置的Cookie并在之后的请求中带上，默认不保存")
+	fs.BoolVar(&o.vuPool, "vu-pool", false, "每个虚拟用户使用自己的连接池，默认所有虚拟用户共用一个连接池")
+	fs.BoolVar(&o.http2, "http2", false, "HTTPS请求通过ALPN协商使用HTTP/2，服务端不支持时回退到HTTP/1.1")
+	fs.BoolVar(&o.h2c, "h2c", false, "HTTP请求直接使用明文的HTTP/2（h2c），HTTPS请求只使用HTTP/2（与http2互斥）")
+	fs.IntVar(&o.h2Conns, "h2-conns", 0, "HTTP/2的连接数，默认由连接池按需建立")
+	fs.IntVar(&o.h2Streams, "h2-streams", 0, "每个HTTP/2连接上的最大并发流数，默认由服务端限制")
+	fs.StringVar(&o.tlsCert, "tls-cert", "", "双向TLS的客户端证书文件（PEM格式），需要同时指定 tls-key")
+	fs.StringVar(&o.tlsKey, "tls-key", "", "客户端证书的私钥文件（PEM格式）")
+	fs.StringVar(&o.tlsCA, "tls-ca", "", "校验服务端证书的CA证书文件（PEM格式），指定后代替系统的CA证书")
+	fs.StringVar(&o.tlsServerName, "tls-server-name", "", "SNI和校验证书时使用的服务端名字，默认为URL中的主机名")
+	fs.BoolVar(&o.tlsInsecure, "tls-insecure", false, "不校验服务端证书，用于自签名证书")
+	fs.StringVar(&o.tlsMinVersion, "tls-min-version", "", "TLS最低版本：1.0、1.1、1.2、1.3")
+	fs.StringVar(&o.tlsMaxVersion, "tls-max-version", "", "TLS最高版本：1.0、1.1、1.2、1.3")
+	fs.StringVar(&o.tlsCiphers, "tls-ciphers", "", "逗号分隔的密码套件，如 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256，只对TLS 1.2及以下的版本生效")
+	fs.BoolVar(&o.tlsResumption, "tls-resumption", false, "复用TLS会话，新连接可以不进行完整握手，默认每个新连接都进行完整握手")
+	fs.StringVar(&o.connReuseSpec, "conn-reuse", "keepalive", "连接复用方式：keepalive 长连接，close 每个请求新建连接，max-requests:N 每个连接最多N个请求，pool:N 固定N个连接")
+	fs.DurationVar(&o.gracePeriod, "grace-period", 5*time.Second, "收到 Ctrl-C 或 SIGTERM 后等待正在发送的请求完成的最长时间，超过后取消这些请求并输出结果")
+	fs.BoolVar(&o.noFastPath, "no-fast-path", false, "请求固定时也逐个生成请求，默认预先构造请求并复用缓冲区，用于排查问题和对比性能")
+	fs.Var(&o.thresholds, "threshold", "压测结束后检查的阈值，如 'p99<200ms'、'error_rate<0.1%'、'rps>=950'，可多次指定")
+}
+
+// stringList 可多次指定的字符串参数
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parseArgs 解析命令行参数，usage 为用法说明的第一行。fs 需要使用 flag.ContinueOnError，
+// 参数有误时已经打印了原因和用法，返回的错误只用于 parseExitCode 决定退出码
+func parseArgs(fs *flag.FlagSet, args []string, usage string) error {
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "用法: %s\n\n", usage)
+		fmt.Fprintf(os.Stderr, "选项:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n注意：布尔类型参数（如 --enable-second-stats）不需要指定值，直接使用参数名即可\n")
+	}
+
+	// 收集布尔类型的参数，这些参数不需要指定值
+	boolFlags := make(map[string]bool)
+	fs.VisitAll(func(f *flag.Flag) {
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			boolFlags["--"+f.Name] = true
+		}
+	})
+
+	// 检查是否有缺少值的参数
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if strings.HasPrefix(arg, "--") {
+			// 检查是否是布尔参数或帮助参数
+			if boolFlags[arg] || arg == "--help" || arg == "--h" {
+				continue
+			}
+			// 检查下一个参数是否是值
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "--") {
+				i++ // 跳过值
+				continue
+			}
+			// 检查是否是等号形式
+			if strings.Contains(arg, "=") {
+				continue
+			}
+			fmt.Printf("错误：未知参数 %s\n", arg)
+			fs.Usage()
+			return fmt.Errorf("未知参数 %s", arg)
+		}
+	}
+
+	return fs.Parse(args)
+}
+
+// parseExitCode 返回 parseArgs 出错时的退出码，只是查看帮助时为0
+func parseExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	return exitUsageError
+}
+
+// parseLoadModel 解析负载阶段和到达过程
+func (o *options) parseLoadModel() error {
+	if o.stagesSpec != "" && o.stagesFile != "" {
+		return errors.New("--stages 和 --stages-file 参数不能同时使用")
+	}
+	if o.stagesSpec != "" || o.stagesFile != "" {
+		var err error
+		if o.stagesFile != "" {
+			o.stages, err = worker.LoadStagesFile(o.stagesFile)
+		} else {
+			o.stages, err = worker.ParseStages(o.stagesSpec)
+		}
+		if err != nil {
+			return fmt.Errorf("解析负载阶段失败: %v", err)
+		}
+		o.duration = int(worker.StagesDuration(o.stages).Seconds())
+	}
+
+	var err error
+	if o.mode, err = worker.ParseStageMode(o.stageMode); err != nil {
+		return err
+	}
+	if o.arrival, err = worker.ParseArrivalConfig(o.arrivalProcess, o.burstSize, o.burstPeriod); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseChecks 解析成功状态码和响应断言参数
+func (o *options) parseChecks() error {
+	status, err := worker.ParseStatusSet(o.successStatusSpec)
+	if err != nil {
+		return fmt.Errorf("无效的 success-status 参数: %v", err)
+	}
+	o.successStatus = status
+
+	o.assertions = nil
+	for _, expr := range o.assertExprs {
+		a, err := worker.ParseAssertion(expr)
+		if err != nil {
+			return err
+		}
+		o.assertions = append(o.assertions, a)
+	}
+	return nil
+}
+
+// parseTransport 解析TLS和连接复用参数，读取证书文件
+func (o *options) parseTransport() error {
+	reuse, err := worker.ParseConnReuse(o.connReuseSpec)
+	if err != nil {
+		return err
+	}
+	o.connReuse = reuse
+
+	opts := worker.TLSOptions{
+		CertFile:   o.tlsCert,
+		KeyFile:    o.tlsKey,
+		CAFile:     o.tlsCA,
+		ServerName: o.tlsServerName,
+		Insecure:   o.tlsInsecure,
+		MinVersion: o.tlsMinVersion,
+		MaxVersion: o.tlsMaxVersion,
+		Resumption: o.tlsResumption,
+	}
+	if o.tlsCiphers != "" {
+		opts.CipherSuites = strings.Split(o.tlsCiphers, ",")
+	}
+	cfg, err := worker.NewTLSConfig(opts)
+	if err != nil {
+		return fmt.Errorf("无效的TLS参数: %v", err)
+	}
+	o.tlsConfig = cfg
+	return nil
+}
+
+// parseOutputs 解析报告输出参数
+func (o *options) parseOutputs() error {
+	o.outputPaths = make(map[string]string)
+	for _, output := range o.outputs {
+		parts := strings.SplitN(output, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return fmt.Errorf("无效的输出 %q，格式应为 类型=路径", output)
+		}
+		if !outputTypes[parts[0]] {
+			return fmt.Errorf("不支持的报告类型 %q", parts[0])
+		}
+		o.outputPaths[parts[0]] = parts[1]
+	}
+	return nil
+}
+
+// reportConfig 返回写入报告的压测配置
+func (o *options) reportConfig() report.Config {
+	cfg := report.Config{
+		URL:            o.url,
+		Method:         o.method,
+		Headers:        o.headerValues(),
+		Concurrency:    o.concurrency,
+		QPS:            o.qps,
+		DurationSec:    float64(o.duration),
+		TimeoutSec:     o.timeout,
+		MaxWorkers:     o.maxWorkers,
+		SrcIP:          o.srcIP,
+		Request:        o.request,
+		File:           o.file,
+		RequestTpl:     o.reqTemplate,
+		Thresholds:     o.thresholds,
+		EnableSecStats: o.enableSecondStats,
+		MetricsAddr:    o.metricsAddr,
+		SuccessStatus:  o.successStatus.String(),
+		Assertions:     o.assertExprs,
+		Cookies:        o.cookies,
+		VUPool:         o.vuPool,
+		HTTPVersion:    string(o.httpVersion()),
+		H2Conns:        o.h2Conns,
+		H2Streams:      o.h2Streams,
+		ConnReuse:      o.connReuse.String(),
+		TLS: report.TLS{
+			Cert:         o.tlsCert,
+			CA:           o.tlsCA,
+			ServerName:   o.tlsServerName,
+			Insecure:     o.tlsInsecure,
+			MinVersion:   o.tlsMinVersion,
+			MaxVersion:   o.tlsMaxVersion,
+			CipherSuites: o.tlsCiphers,
+			Resumption:   o.tlsResumption,
+		},
+		FastPath: !o.noFastPath,
+	}
+	switch {
+	case o.concurrency > 0:
+		cfg.Mode = "concurrency"
+	case len(o.stages) > 0:
+		cfg.Mode = "stages"
+		cfg.StageMode = string(o.mode)
+		for _, stage := range o.stages {
+			cfg.Stages = append(cfg.Stages, report.Stage{DurationSec: stage.Duration.Seconds(), Target: stage.Target})
+		}
+		cfg.DurationSec = worker.StagesDuration(o.stages).Seconds()
+	default:
+		cfg.Mode = "qps"
+	}
+	if o.concurrency == 0 {
+		cfg.Arrival = &report.Arrival{
+			Process:        string(o.arrival.Process),
+			BurstSize:      o.arrival.BurstSize,
+			BurstPeriodSec: o.arrival.BurstPeriod.Seconds(),
+		}
+	}
+	return cfg
+}
+
+// headerValues 返回额外的HTTP头部
+func (o *options) headerValues() map[string]string {
+	if o.headerMap != nil {
+		return o.headerMap
+	}
+	return worker.ParseHeaders(o.headers)
+}
+
+// printRequest 打印请求相关的参数
+func (o *options) printRequest() {
+	if len(o.endpoints) == 0 && len(o.flow) == 0 {
+		fmt.Printf("  URL: %s\n", o.url)
+	}
+	fmt.Printf("  请求方法: %s\n", o.method)
+	for _, ep := range o.endpoints {
+		fmt.Printf("  接口 %s: 权重 %d, %s %s\n", ep.name, ep.weight, o.methodOf(ep), ep.url)
+	}
+	for i, step := range o.flow {
+		fmt.Printf("  步骤%d %s: %s %s\n", i+1, step.name, o.methodOf(step), step.url)
+		for _, name := range sortedKeys(step.extract) {
+			fmt.Printf("    提取 %s: %s\n", name, step.extract[name])
+		}
+	}
+	if o.headerMap != nil {
+		keys := make([]string, 0, len(o.headerMap))
+		for key := range o.headerMap {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("  额外头部: %s: %s\n", key, o.headerMap[key])
+		}
+	} else if o.headers != "" {
+		fmt.Printf("  额外头部: %s\n", o.headers)
+	}
+}
+
+// printCommon 打印压测模式之外的通用参数
+func (o *options) printCommon() {
+	fmt.Printf("  超时时间: %.3f秒\n", o.timeout)
+	fmt.Printf("  最大并发数: %d\n", o.maxWorkers)
+	fmt.Printf("  每秒统计: %v\n", o.enableSecondStats)
+	if o.srcIP != "" {
+		fmt.Printf("  源IP地址: %s\n", o.srcIP)
+	}
+	if o.metricsAddr != "" {
+		fmt.Printf("  指标地址: %s\n", o.metricsAddr)
+	}
+	fmt.Printf("  成功状态码: %s\n", o.successStatus)
+	if len(o.assertExprs) > 0 {
+		fmt.Printf("  断言: %s\n", strings.Join(o.assertExprs, " "))
+	}
+	fmt.Printf("  Cookie: %v\n", o.cookies)
+	fmt.Printf("  每个虚拟用户独立连接池: %v\n", o.vuPool)
+	fmt.Printf("  HTTP协议: %s\n", o.httpVersion())
+	fmt.Printf("  连接复用: %s\n", o.connReuse)
+	if o.h2Conns > 0 {
+		fmt.Printf("  HTTP/2连接数: %d\n", o.h2Conns)
+	}
+	if o.h2Streams > 0 {
+		fmt.Printf("  每个HTTP/2连接的最大并发流数: %d\n", o.h2Streams)
+	}
+	if o.tlsCert != "" {
+		fmt.Printf("  客户端证书: %s\n", o.tlsCert)
+	}
+	if o.tlsCA != "" {
+		fmt.Printf("  CA证书: %s\n", o.tlsCA)
+	}
+	if o.tlsServerName != "" {
+		fmt.Printf("  TLS服务端名字: %s\n", o.tlsServerName)
+	}
+	if o.tlsInsecure {
+		fmt.Printf("  不校验服务端证书: true\n")
+	}
+	if o.tlsMinVersion != "" {
+		fmt.Printf("  TLS最低版本: %s\n", o.tlsMinVersion)
+	}
+	if o.tlsMaxVersion != "" {
+		fmt.Printf("  TLS最高版本: %s\n", o.tlsMaxVersion)
+	}
+	if o.tlsCiphers != "" {
+		fmt.Printf("  密码套件: %s\n", o.tlsCiphers)
+	}
+	fmt.Printf("  TLS会话复用: %v\n", o.tlsResumption)
+	fmt.Printf("  中断宽限期: %v\n", o.gracePeriod)
+	fmt.Printf("  预先构造固定请求: %v\n", !o.noFastPath)
+
+	if o.request != "" {
+		fmt.Printf("  请求体: %s\n", o.request)
+	} else if o.reqTemplate != "" {
+		fmt.Printf("  请求模板: %s\n", o.reqTemplate)
+		fmt.Printf("  文件路径: %s\n", o.file)
+	} else if o.file != "" {
+		fmt.Printf("  文件路径: %s\n", o.file)
+	}
+}
+
+// validateLoadMode 验证压测模式相关的参数
+func (o *options) validateLoadMode() error {
+	if o.concurrency > 0 && o.qps > 0 {
+		return errors.New("concurrency 和 qps 参数不能同时使用")
+	}
+	if len(o.stages) > 0 && (o.concurrency > 0 || o.qps > 0) {
+		return errors.New("stages 不能与 concurrency 或 qps 参数同时使用")
+	}
+	if o.concurrency == 0 && o.qps == 0 && len(o.stages) == 0 {
+		return errors.New("必须指定 concurrency、qps 或 stages 参数")
+	}
+	if len(o.stages) > 0 {
+		maxTarget := 0
+		for _, stage := range o.stages {
+			if stage.Target > maxTarget {
+				maxTarget = stage.Target
+			}
+		}
+		if maxTarget == 0 {
+			return errors.New("stages 中至少需要一个阶段的QPS大于0")
+		}
+	}
+	return nil
+}
+
+// validateCommon 验证请求来源、源IP等通用参数
+func (o *options) validateCommon() error {
+	if o.concurrency == 0 && o.maxWorkers <= 0 {
+		return errors.New("QPS模式下必须指定大于0的max-workers参数")
+	}
+
+	if err := validateBody(o.request, o.file, o.reqTemplate); err != nil {
+		return err
+	}
+
+	// 验证src-ip参数
+	if o.srcIP != "" {
+		if net.ParseIP(o.srcIP) == nil {
+			return fmt.Errorf("无效的IP地址 %s", o.srcIP)
+		}
+		// 检查IP地址是否可用
+		if !isIPAvailable(o.srcIP) {
+			return fmt.Errorf("IP地址 %s 不可用或不存在于本机", o.srcIP)
+		}
+	}
+
+	if o.gracePeriod < 0 {
+		return errors.New("grace-period 不能小于0")
+	}
+
+	if o.http2 && o.h2c {
+		return errors.New("http2 和 h2c 参数不能同时使用")
+	}
+	if o.h2Conns < 0 || o.h2Streams < 0 {
+		return errors.New("h2-conns 和 h2-streams 不能小于0")
+	}
+	if (o.h2Conns > 0 || o.h2Streams > 0) && o.httpVersion() == worker.HTTP1 {
+		return errors.New("h2-conns 和 h2-streams 需要与 --http2 或 --h2c 一起使用")
+	}
+	if o.connReuse.Mode != worker.ReuseKeepAlive && o.httpVersion() != worker.HTTP1 {
+		return errors.New("HTTP/2只支持 keepalive 连接复用方式，连接数通过 h2-conns 控制")
+	}
+
+	for _, ep := range append(o.endpoints, o.flow...) {
+		if ep.url == "" {
+			return fmt.Errorf("接口 %s 缺少 url", ep.name)
+		}
+		if ep.weight <= 0 {
+			return fmt.Errorf("接口 %s 的权重必须大于0", ep.name)
+		}
+		if err := validateBody(ep.request, ep.file, ep.reqTemplate); err != nil {
+			return fmt.Errorf("接口 %s: %v", ep.name, err)
+		}
+	}
+	return nil
+}
+
+// httpVersion 返回 --http2 和 --h2c 指定的HTTP协议
+func (o *options) httpVersion() worker.HTTPVersion {
+	switch {
+	case o.h2c:
+		return worker.H2C
+	case o.http2:
+		return worker.HTTP2
+	}
+	return worker.HTTP1
+}
+
+// validateBody 验证请求体来源相关的参数
+func validateBody(request, file, reqTemplate string) error {
+	// 验证request参数
+	if request != "" && (file != "" || reqTemplate != "") {
+		return errors.New("使用 --request 参数时，--file 和 --req-template 必须为空")
+	}
+
+	// 验证文件相关参数
+	if reqTemplate != "" && file == "" {
+		return errors.New("使用 --req-template 时必须指定 --file 参数")
+	}
+	if file != "" {
+		// Split files by comma and validate each one
+		files := strings.Split(file, ",")
+		for _, f := range files {
+			f = strings.TrimSpace(f)
+			if _, err := os.Stat(f); os.IsNotExist(err) {
+				return fmt.Errorf("文件 %s 不存在", f)
+			}
+			// If template is specified, validate CSV format for each file
+			if reqTemplate != "" {
+				ext := strings.ToLower(filepath.Ext(f))
+				if ext != ".csv" {
+					return fmt.Errorf("使用 --req-template 时，文件 %s 必须是CSV格式", f)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// newGenerator 创建请求生成器
+func (o *options) newGenerator() (gen.RequestGenerator, error) {
+	// 初始化随机数生成器
+	rand.Seed(time.Now().UnixNano())
+
+	vars := o.extractVars()
+	templated := hasColumnVars(o.method, o.url, o.headerValues(), vars)
+	generator, err := newBodyGenerator(o.request, o.file, o.reqTemplate, templated, vars)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTemplateVars(generator, o.method, o.url, o.headerValues(), vars); err != nil {
+		return nil, err
+	}
+	return generator, nil
+}
+
+// extractVars 返回场景文件中所有接口和步骤提取的变量名，这些变量可以在模板中引用
+func (o *options) extractVars() []string {
+	var vars []string
+	for _, ep := range append(o.endpoints, o.flow...) {
+		vars = append(vars, sortedKeys(ep.extract)...)
+	}
+	return vars
+}
+
+// methodOf 返回接口的请求方法，未指定时与 --method 相同
+func (o *options) methodOf(ep endpointSpec) string {
+	if ep.method == "" {
+		return o.method
+	}
+	return ep.method
+}
+
+// sortedKeys 返回排好序的key
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkTemplateVars 检查方法、URL和头部中的占位符都能由请求生成器填充。内置函数和提取的变量总是可用，
+// CSV列只有CSV模板生成器能提供，与请求体模板来自CSV文件的同一行。
+func checkTemplateVars(generator gen.RequestGenerator, method, url string, headers map[string]string, vars []string) error {
+	texts := []string{method, url}
+	for _, value := range headers {
+		texts = append(texts, value)
+	}
+	for _, text := range texts {
+		if err := gen.CheckTemplate(generator, text, vars...); err != nil {
+			return fmt.Errorf("%q 中的模板无效: %v", text, err)
+		}
+	}
+	return nil
+}
+
+// hasColumnVars 判断方法、URL或头部中是否引用了 vars 以外的变量，即CSV列
+func hasColumnVars(method, url string, headers map[string]string, vars []string) bool {
+	if len(gen.TemplateColumns(method, vars)) > 0 || len(gen.TemplateColumns(url, vars)) > 0 {
+		return true
+	}
+	for _, value := range headers {
+		if len(gen.TemplateColumns(value, vars)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// newBodyGenerator 按请求体来源创建请求生成器。templated 表示URL等处引用了CSV列，
+// 此时即使没有请求体模板，也按CSV模板读取文件，生成的请求体为空。
+// vars 为模板中可以引用的变量名。
+func newBodyGenerator(request, file, reqTemplate string, templated bool, vars []string) (gen.RequestGenerator, error) {
+	if file != "" {
+		if reqTemplate != "" || templated {
+			// 使用模板生成器
+			reqGenerator, err := gen.NewTplGenerator(file, reqTemplate, vars...)
+			if err != nil {
+				return nil, fmt.Errorf("创建模板生成器失败: %v", err)
+			}
+			return reqGenerator, nil
+		}
+		// 使用文件生成器
+		reqGenerator, err := gen.NewFileGenerator(file)
+		if err != nil {
+			return nil, fmt.Errorf("创建文件生成器失败: %v", err)
+		}
+		return reqGenerator, nil
+	}
+	if strings.Contains(request, "${") {
+		// 请求体中使用了内置函数
+		reqGenerator, err := gen.NewTemplateRequestGenerator(request, vars...)
+		if err != nil {
+			return nil, fmt.Errorf("解析请求体模板失败: %v", err)
+		}
+		return reqGenerator, nil
+	}
+	if request != "" {
+		return gen.NewSimpleRequestGenerator(request), nil
+	}
+	return gen.NewCustomRequestGenerator(), nil
+}
+
+// newEndpoints 按场景文件中的接口或步骤创建 worker.Endpoint，未指定请求体的接口共用 generator。
+// 接口的方法默认与 --method 相同，头部在 --header 的基础上覆盖。
+func (o *options) newEndpoints(specs []endpointSpec, generator gen.RequestGenerator) ([]worker.Endpoint, error) {
+	vars := o.extractVars()
+	endpoints := make([]worker.Endpoint, 0, len(specs))
+	for _, spec := range specs {
+		ep := worker.Endpoint{
+			Name:      spec.name,
+			Weight:    spec.weight,
+			Method:    spec.method,
+			URL:       spec.url,
+			Headers:   make(map[string]string),
+			Generator: generator,
+		}
+		ep.Method = o.methodOf(spec)
+		for key, value := range o.headerValues() {
+			ep.Headers[key] = value
+		}
+		for key, value := range spec.headers {
+			ep.Headers[key] = value
+		}
+		if spec.request != "" || spec.file != "" {
+			templated := hasColumnVars(ep.Method, ep.URL, ep.Headers, vars)
+			var err error
+			if ep.Generator, err = newBodyGenerator(spec.request, spec.file, spec.reqTemplate, templated, vars); err != nil {
+				return nil, fmt.Errorf("接口 %s: %v", spec.name, err)
+			}
+		}
+		if err := checkTemplateVars(ep.Generator, ep.Method, ep.URL, ep.Headers, vars); err != nil {
+			return nil, fmt.Errorf("接口 %s: %v", spec.name, err)
+		}
+		for _, name := range sortedKeys(spec.extract) {
+			e, err := worker.ParseExtractor(name, spec.extract[name])
+			if err != nil {
+				return nil, fmt.Errorf("接口 %s: %v", spec.name, err)
+			}
+			ep.Extract = append(ep.Extract, e)
+		}
+		endpoints = append(endpoints, ep)
+	}
+	return endpoints, nil
+}
+
+// newWorker 按参数创建压测工作器，qps 和 duration 由调用方指定以便搜索模式逐级调整
+func (o *options) newWorker(generator gen.RequestGenerator, qps int, duration time.Duration, enableSecondStats bool) *worker.Worker {
+	w := worker.NewWorker(o.url, o.concurrency, duration, time.Duration(o.timeout*1000)*time.Millisecond, qps, generator, enableSecondStats, o.method, o.headers, o.srcIP)
+	if w == nil {
+		return nil
+	}
+	w.SetMaxWorkers(int32(o.maxWorkers))
+	if len(o.stages) > 0 {
+		w.SetStages(o.stages, o.mode)
+	}
+	w.SetArrival(o.arrival)
+	if o.headerMap != nil {
+		w.SetHeaders(o.headerMap)
+	}
+	w.SetSuccessStatus(o.successStatus)
+	w.SetAssertions(o.assertions)
+	w.SetSession(worker.SessionConfig{Cookies: o.cookies, OwnPool: o.vuPool})
+	w.SetProtocol(worker.ProtocolConfig{Version: o.httpVersion(), Conns: o.h2Conns, Streams: o.h2Streams})
+	w.SetTLSConfig(o.tlsConfig)
+	w.SetConnReuse(o.connReuse)
+	w.SetGracePeriod(o.gracePeriod)
+	w.SetFastPath(!o.noFastPath)
+	if o.metrics != nil {
+		o.metrics.SetStats(w.GetStats())
+	}
+	return w
+}
+
+// startMetrics 指定了 --metrics-addr 时启动 /metrics 接口，之后创建的工作器的统计数据会通过该接口导出
+func (o *options) startMetrics() error {
+	if o.metricsAddr == "" {
+		return nil
+	}
+	server, err := metrics.Serve(o.metricsAddr)
+	if err != nil {
+		return err
+	}
+	o.metrics = server
+	return nil
+}
+
+// stopMetrics 关闭 /metrics 接口
+func (o *options) stopMetrics() {
+	if o.metrics != nil {
+		o.metrics.Close()
+	}
+}
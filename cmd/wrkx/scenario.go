@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/panzhongxian/wrkx/internal/worker"
+	"gopkg.in/yaml.v3"
+)
+
+// scenario 场景文件，用一个YAML或JSON文件描述一次压测，代替冗长的命令行参数。
+// 每个字段都对应一个命令行参数，命令行中显式指定的参数会覆盖场景文件中的同名字段。
+type scenario struct {
+	Target            scenarioTarget     `yaml:"target"`
+	Body              scenarioBody       `yaml:"body"`
+	Endpoints         []scenarioEndpoint `yaml:"endpoints"`
+	Flow              []scenarioEndpoint `yaml:"flow"`
+	Load              scenarioLoad       `yaml:"load"`
+	Timeout           *scenarioDuration  `yaml:"timeout"`
+	SuccessStatus     string             `yaml:"success_status"`
+	Assert            []string           `yaml:"assert"`
+	Thresholds        []string           `yaml:"thresholds"`
+	Outputs           map[string]string  `yaml:"outputs"`
+	MetricsAddr       string             `yaml:"metrics_addr"`
+	EnableSecondStats *bool              `yaml:"enable_second_stats"`
+	Session           scenarioSession    `yaml:"session"`
+	Protocol          scenarioProtocol   `yaml:"protocol"`
+	TLS               scenarioTLS        `yaml:"tls"`
+	ConnReuse         string             `yaml:"conn_reuse"`
+	GracePeriod       *scenarioDuration  `yaml:"grace_period"`
+}
+
+// scenarioTLS HTTPS请求的TLS客户端设置
+type scenarioTLS struct {
+	Cert         string   `yaml:"cert"`
+	Key          string   `yaml:"key"`
+	CA           string   `yaml:"ca"`
+	ServerName   string   `yaml:"server_name"`
+	Insecure     *bool    `yaml:"insecure"`
+	MinVersion   string   `yaml:"min_version"`
+	MaxVersion   string   `yaml:"max_version"`
+	CipherSuites []string `yaml:"cipher_suites"`
+	Resumption   *bool    `yaml:"resumption"` // 默认为false
+}
+
+// scenarioProtocol HTTP协议和HTTP/2连接的设置
+type scenarioProtocol struct {
+	Version string `yaml:"version"` // http1、http2 或 h2c，默认为 http1
+	Conns   int    `yaml:"conns"`
+	Streams int    `yaml:"streams"`
+}
+
+// scenarioSession 虚拟用户的会话设置
+type scenarioSession struct {
+	Cookies *bool `yaml:"cookies"`  // 默认为false
+	OwnPool *bool `yaml:"own_pool"` // 默认为false
+}
+
+// scenarioTarget 压测目标
+type scenarioTarget struct {
+	URL     string            `yaml:"url"`
+	Method  string            `yaml:"method"`
+	Headers map[string]string `yaml:"headers"` // 头部的值可以包含逗号
+	SrcIP   string            `yaml:"src_ip"`
+}
+
+// scenarioBody 请求体来源，三者只能指定一个
+type scenarioBody struct {
+	Inline   string `yaml:"inline"`
+	File     string `yaml:"file"`
+	Template string `yaml:"template"`
+}
+
+// scenarioEndpoint 多接口压测中的一个接口，请求按权重在各接口之间随机分配；也用作多步骤流程中的一个步骤。
+// 未指定的方法和请求体使用 target 和 body 中的设置，头部在 target 的基础上覆盖。
+type scenarioEndpoint struct {
+	Name    string            `yaml:"name"`
+	Weight  *int              `yaml:"weight"` // 默认为1，流程中的步骤不能指定
+	Method  string            `yaml:"method"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Body    scenarioBody      `yaml:"body"`
+	Extract map[string]string `yaml:"extract"` // 变量名到提取规则，如 token: json:data.token
+}
+
+// endpointSpec 场景文件中的一个接口或步骤，由 options.newEndpoints 转换为 worker.Endpoint
+type endpointSpec struct {
+	name        string
+	weight      int
+	method      string
+	url         string
+	headers     map[string]string
+	request     string
+	file        string
+	reqTemplate string
+	extract     map[string]string
+}
+
+// scenarioLoad 负载模型
+type scenarioLoad struct {
+	Concurrency int               `yaml:"concurrency"`
+	QPS         int               `yaml:"qps"`
+	Stages      []scenarioStage   `yaml:"stages"`
+	StageMode   string            `yaml:"stage_mode"`
+	Arrival     string            `yaml:"arrival"`
+	BurstSize   int               `yaml:"burst_size"`
+	BurstPeriod *scenarioDuration `yaml:"burst_period"`
+	Duration    *scenarioDuration `yaml:"duration"`
+	MaxWorkers  int               `yaml:"max_workers"`
+}
+
+// scenarioStage 负载曲线中的一个阶段
+type scenarioStage struct {
+	Duration scenarioDuration `yaml:"duration"`
+	Target   int              `yaml:"target"`
+}
+
+// scenarioDuration 场景文件中的时长，支持 30s、1m30s 等写法，不带单位的数字视为秒
+type scenarioDuration time.Duration
+
+func (d *scenarioDuration) UnmarshalYAML(node *yaml.Node) error {
+	if v, err := strconv.ParseFloat(node.Value, 64); err == nil {
+		*d = scenarioDuration(v * float64(time.Second))
+		return nil
+	}
+	v, err := time.ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: 无效的时长 %q", node.Line, node.Value)
+	}
+	*d = scenarioDuration(v)
+	return nil
+}
+
+// loadScenario 读取并严格校验场景文件，未知的字段会连同行号一起报错
+func loadScenario(path string) (*scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取场景文件失败: %v", err)
+	}
+
+	var s scenario
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&s); err != nil {
+		return nil, fmt.Errorf("解析场景文件 %s 失败: %v", path, err)
+	}
+	return &s, nil
+}
+
+// apply 将场景文件中的字段写入参数，set 中为命令行显式指定的参数，这些参数保持命令行的值
+func (s *scenario) apply(o *options, set map[string]bool) error {
+	setString := func(name string, dst *string, v string) {
+		if v != "" && !set[name] {
+			*dst = v
+		}
+	}
+	setInt := func(name string, dst *int, v int) {
+		if v != 0 && !set[name] {
+			*dst = v
+		}
+	}
+
+	setString("url", &o.url, s.Target.URL)
+	// 命令行指定了 --url 时只压测该URL，忽略场景文件中的多个接口和多步骤流程
+	if !set["url"] {
+		if len(s.Endpoints) > 0 && len(s.Flow) > 0 {
+			return errors.New("场景文件中的 endpoints 和 flow 不能同时使用")
+		}
+		var err error
+		if o.endpoints, err = endpointSpecs(s.Endpoints, "endpoint"); err != nil {
+			return err
+		}
+		if o.flow, err = endpointSpecs(s.Flow, "step"); err != nil {
+			return err
+		}
+	}
+	setString("method", &o.method, s.Target.Method)
+	setString("src-ip", &o.srcIP, s.Target.SrcIP)
+	if s.Target.Headers != nil && !set["header"] {
+		o.headerMap = s.Target.Headers
+	}
+
+	// 请求体来源和压测模式是互斥的一组参数，命令行指定了其中任意一个时整组都以命令行为准
+	if !set["request"] && !set["file"] && !set["req-template"] {
+		o.request = s.Body.Inline
+		o.file = s.Body.File
+		o.reqTemplate = s.Body.Template
+	}
+	if !set["concurrency"] && !set["qps"] && !set["stages"] && !set["stages-file"] {
+		o.concurrency = s.Load.Concurrency
+		o.qps = s.Load.QPS
+		if len(s.Load.Stages) > 0 {
+			specs := make([]string, len(s.Load.Stages))
+			for i, stage := range s.Load.Stages {
+				specs[i] = fmt.Sprintf("%s:%d", time.Duration(stage.Duration), stage.Target)
+			}
+			o.stagesSpec = strings.Join(specs, ",")
+		}
+	}
+	setInt("max-workers", &o.maxWorkers, s.Load.MaxWorkers)
+	setString("stage-mode", &o.stageMode, s.Load.StageMode)
+	setString("arrival", &o.arrivalProcess, s.Load.Arrival)
+	setInt("burst-size", &o.burstSize, s.Load.BurstSize)
+	if s.Load.BurstPeriod != nil && !set["burst-period"] {
+		o.burstPeriod = time.Duration(*s.Load.BurstPeriod)
+	}
+	if s.Load.Duration != nil && !set["duration"] {
+		seconds := time.Duration(*s.Load.Duration).Seconds()
+		if seconds != math.Trunc(seconds) {
+			return errors.New("场景文件中的 load.duration 必须是整数秒")
+		}
+		o.duration = int(seconds)
+	}
+
+	if s.Timeout != nil && !set["timeout"] {
+		o.timeout = time.Duration(*s.Timeout).Seconds()
+	}
+	setString("success-status", &o.successStatusSpec, s.SuccessStatus)
+	if len(s.Assert) > 0 && !set["assert"] {
+		o.assertExprs = s.Assert
+	}
+	if len(s.Thresholds) > 0 && !set["threshold"] {
+		o.thresholds = s.Thresholds
+	}
+	if len(s.Outputs) > 0 && !set["output"] {
+		types := make([]string, 0, len(s.Outputs))
+		for typ := range s.Outputs {
+			types = append(types, typ)
+		}
+		sort.Strings(types)
+		o.outputs = nil
+		for _, typ := range types {
+			o.outputs = append(o.outputs, typ+"="+s.Outputs[typ])
+		}
+	}
+	setString("metrics-addr", &o.metricsAddr, s.MetricsAddr)
+	if s.EnableSecondStats != nil && !set["enable-second-stats"] {
+		o.enableSecondStats = *s.EnableSecondStats
+	}
+	if s.Session.Cookies != nil && !set["cookies"] {
+		o.cookies = *s.Session.Cookies
+	}
+	if s.Session.OwnPool != nil && !set["vu-pool"] {
+		o.vuPool = *s.Session.OwnPool
+	}
+	if s.Protocol.Version != "" && !set["http2"] && !set["h2c"] {
+		version, err := worker.ParseHTTPVersion(s.Protocol.Version)
+		if err != nil {
+			return fmt.Errorf("场景文件中的 protocol.version: %v", err)
+		}
+		o.http2 = version == worker.HTTP2
+		o.h2c = version == worker.H2C
+	}
+	setInt("h2-conns", &o.h2Conns, s.Protocol.Conns)
+	setInt("h2-streams", &o.h2Streams, s.Protocol.Streams)
+	setString("conn-reuse", &o.connReuseSpec, s.ConnReuse)
+	if s.GracePeriod != nil && !set["grace-period"] {
+		o.gracePeriod = time.Duration(*s.GracePeriod)
+	}
+	setString("tls-cert", &o.tlsCert, s.TLS.Cert)
+	setString("tls-key", &o.tlsKey, s.TLS.Key)
+	setString("tls-ca", &o.tlsCA, s.TLS.CA)
+	setString("tls-server-name", &o.tlsServerName, s.TLS.ServerName)
+	if s.TLS.Insecure != nil && !set["tls-insecure"] {
+		o.tlsInsecure = *s.TLS.Insecure
+	}
+	setString("tls-min-version", &o.tlsMinVersion, s.TLS.MinVersion)
+	setString("tls-max-version", &o.tlsMaxVersion, s.TLS.MaxVersion)
+	setString("tls-ciphers", &o.tlsCiphers, strings.Join(s.TLS.CipherSuites, ","))
+	if s.TLS.Resumption != nil && !set["tls-resumption"] {
+		o.tlsResumption = *s.TLS.Resumption
+	}
+	return nil
+}
+
+// endpointSpecs 转换场景文件中的接口或步骤，未指定名字时以 prefix 加序号命名
+func endpointSpecs(list []scenarioEndpoint, prefix string) ([]endpointSpec, error) {
+	var specs []endpointSpec
+	names := make(map[string]bool)
+	for i, ep := range list {
+		spec := endpointSpec{
+			name:        ep.Name,
+			weight:      1,
+			method:      ep.Method,
+			url:         ep.URL,
+			headers:     ep.Headers,
+			request:     ep.Body.Inline,
+			file:        ep.Body.File,
+			reqTemplate: ep.Body.Template,
+			extract:     ep.Extract,
+		}
+		if spec.name == "" {
+			spec.name = fmt.Sprintf("%s%d", prefix, i+1)
+		}
+		if names[spec.name] {
+			return nil, fmt.Errorf("场景文件中的名字 %s 重复", spec.name)
+		}
+		names[spec.name] = true
+		if ep.Weight != nil {
+			if prefix == "step" {
+				return nil, fmt.Errorf("流程中的步骤 %s 不能指定 weight", spec.name)
+			}
+			spec.weight = *ep.Weight
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// runScenario 按场景文件执行一次压测，命令行参数可以覆盖场景文件中的字段
+func runScenario(args []string) int {
+	usage := fmt.Sprintf("%s run 场景文件 [选项]", os.Args[0])
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Printf("错误：缺少场景文件\n用法: %s\n", usage)
+		return exitUsageError
+	}
+
+	s, err := loadScenario(args[0])
+	if err != nil {
+		fmt.Printf("错误：%v\n", err)
+		return exitUsageError
+	}
+
+	var opts options
+	fs := flag.NewFlagSet("wrkx run", flag.ContinueOnError)
+	opts.register(fs)
+	if err := parseArgs(fs, args[1:], usage); err != nil {
+		return parseExitCode(err)
+	}
+	if fs.NArg() > 0 {
+		fmt.Printf("错误：多余的参数 %s\n", strings.Join(fs.Args(), " "))
+		return exitUsageError
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if err := s.apply(&opts, set); err != nil {
+		fmt.Printf("错误：%v\n", err)
+		return exitUsageError
+	}
+	return executeLoad(&opts)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/panzhongxian/wrkx/internal/worker"
+)
+
+// searchOptions 搜索模式特有的参数
+type searchOptions struct {
+	strategy    string
+	start       int
+	max         int
+	step        int
+	cooldown    time.Duration
+	sloP99      time.Duration
+	sloError    float64
+	sloAchieved float64
+}
+
+func (o *searchOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.strategy, "search-strategy", "step", "搜索策略：step 从起始QPS逐级递增，binary 在起始QPS和最大QPS之间二分查找")
+	fs.IntVar(&o.start, "search-start", 100, "搜索的起始QPS")
+	fs.IntVar(&o.max, "search-max", 10000, "搜索的最大QPS")
+	fs.IntVar(&o.step, "search-step", 100, "step策略下每级递增的QPS，binary策略下的搜索精度")
+	fs.DurationVar(&o.cooldown, "search-cooldown", 3*time.Second, "每级压测之间的冷却时间，让目标服务恢复")
+	fs.DurationVar(&o.sloP99, "slo-p99", 0, "SLO：p99响应时间（含排队）的上限，如 200ms，0表示不检查")
+	fs.Float64Var(&o.sloError, "slo-error-rate", 1, "SLO：错误率上限（百分比），负数表示不检查")
+	fs.Float64Var(&o.sloAchieved, "slo-achieved", 95, "SLO：完成率（成功请求数占计划发送请求数的百分比）的下限，负数表示不检查")
+}
+
+func (o *searchOptions) validate() error {
+	if o.strategy != "step" && o.strategy != "binary" {
+		return fmt.Errorf("未知的搜索策略 %q，可选值为 step 或 binary", o.strategy)
+	}
+	if o.start <= 0 || o.max < o.start {
+		return errors.New("search-start 必须大于0且不大于 search-max")
+	}
+	if o.step <= 0 {
+		return errors.New("search-step 必须大于0")
+	}
+	if o.sloP99 <= 0 && o.sloError < 0 && o.sloAchieved < 0 {
+		return errors.New("至少需要指定一个SLO")
+	}
+	return nil
+}
+
+// searchLevel 一级压测的结果
+type searchLevel struct {
+	target   int
+	achieved float64
+	ratio    float64 // 成功请求数占调度器计划发送请求数的百分比
+	p99      time.Duration
+	errRate  float64 // 百分比
+	failures []string
+	// 客户端跟不上目标QPS，这一级的结果无效
+	saturated bool
+}
+
+func (l *searchLevel) passed() bool {
+	return len(l.failures) == 0
+}
+
+// evaluate 根据SLO判断一级压测是否达标
+func (o *searchOptions) evaluate(target int, stats *worker.RequestStats) *searchLevel {
+	level := &searchLevel{
+		target:   target,
+		achieved: stats.RequestsPerSec,
+		p99:      stats.ResponseTimeHistogram.Snapshot().Quantile(0.99),
+		errRate:  stats.ErrorRate() * 100,
+	}
+	if stats.ScheduledRequests > 0 {
+		level.ratio = float64(stats.TotalRequests) * 100 / float64(stats.ScheduledRequests)
+	}
+	if o.sloP99 > 0 && level.p99 >= o.sloP99 {
+		level.failures = append(level.failures, fmt.Sprintf("p99 %v >= %v", level.p99.Round(time.Microsecond), o.sloP99))
+	}
+	if o.sloError >= 0 && level.errRate >= o.sloError {
+		level.failures = append(level.failures, fmt.Sprintf("错误率 %.2f%% >= %.2f%%", level.errRate, o.sloError))
+	}
+	if o.sloAchieved >= 0 && level.ratio < o.sloAchieved {
+		level.failures = append(level.failures, fmt.Sprintf("完成率 %.1f%% < %.1f%%", level.ratio, o.sloAchieved))
+	}
+	// 客户端跟不上时这一级的结果无效，更高的QPS也无法测出，计为不达标：
+	// step 策略到此结束，binary 策略把它作为上界，只在更低的QPS中继续查找
+	if stats.Client.Saturated() {
+		level.saturated = true
+		level.failures = append(level.failures, "客户端已饱和："+strings.Join(stats.Client.Problems(), "，"))
+	}
+	return level
+}
+
+// runSearch 逐级调整QPS，找出满足SLO的最大QPS，返回进程退出码
+func runSearch(args []string) int {
+	var (
+		opts   options
+		search searchOptions
+	)
+	fs := flag.NewFlagSet("wrkx search", flag.ContinueOnError)
+	opts.register(fs)
+	search.register(fs)
+	if err := parseArgs(fs, args, fmt.Sprintf("%s search [选项]", os.Args[0])); err != nil {
+		return parseExitCode(err)
+	}
+
+	if err := opts.parseLoadModel(); err != nil {
+		fmt.Printf("错误：%v\n", err)
+		return exitUsageError
+	}
+	if err := opts.parseChecks(); err != nil {
+		fmt.Printf("错误：%v\n", err)
+		return exitUsageError
+	}
+	if err := opts.parseTransport(); err != nil {
+		fmt.Printf("错误：%v\n", err)
+		return exitUsageError
+	}
+
+	fmt.Printf("参数值:\n")
+	opts.printRequest()
+	fmt.Printf("  模式: 容量搜索, 策略: %s, QPS范围: %d-%d, 步长: %d\n", search.strategy, search.start, search.max, search.step)
+	fmt.Printf("  SLO: %s\n", search.describeSLO())
+	fmt.Printf("  到达过程: %s\n", opts.arrival)
+	fmt.Printf("  每级持续时间: %d秒\n", opts.duration)
+	opts.printCommon()
+	fmt.Println()
+
+	if opts.concurrency > 0 || opts.qps > 0 || len(opts.stages) > 0 {
+		fmt.Println("错误：搜索模式下不能指定 concurrency、qps 或 stages 参数")
+		return exitUsageError
+	}
+	if err := search.validate(); err != nil {
+		fmt.Printf("错误：%v\n", err)
+		return exitUsageError
+	}
+	if err := opts.validateCommon(); err != nil {
+		fmt.Printf("错误：%v\n", err)
+		return exitUsageError
+	}
+
+	if err := opts.startMetrics(); err != nil {
+		fmt.Printf("错误：%v\n", err)
+		return exitUsageError
+	}
+	defer opts.stopMetrics()
+
+	reqGenerator, err := opts.newGenerator()
+	if err != nil {
+		fmt.Println(err)
+		return exitRunError
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	// 被中断的一级压测时间不完整，不参与判断，搜索到此结束
+	var levels []*searchLevel
+	runLevel := func(qps int) *searchLevel {
+		if len(levels) > 0 && search.cooldown > 0 {
+			select {
+			case <-time.After(search.cooldown):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		fmt.Printf("开始压测 QPS %d...\n", qps)
+		w := opts.newWorker(reqGenerator, qps, time.Duration(opts.duration)*time.Second, false)
+		if w == nil {
+			return nil
+		}
+		w.Start(ctx)
+		if w.GetStats().Interrupted {
+			fmt.Printf("QPS %d: 被中断，不计入结果\n\n", qps)
+			return nil
+		}
+		level := search.evaluate(qps, w.GetStats())
+		levels = append(levels, level)
+		if level.passed() {
+			fmt.Printf("QPS %d: 达标\n\n", qps)
+		} else {
+			fmt.Printf("QPS %d: 未达标（%s）\n\n", qps, strings.Join(level.failures, "，"))
+		}
+		return level
+	}
+
+	var capacity int
+	switch search.strategy {
+	case "step":
+		capacity = searchStep(&search, runLevel)
+	case "binary":
+		capacity = searchBinary(&search, runLevel)
+	}
+
+	interrupted := ctx.Err() != nil
+	printSearchResult(levels, capacity, interrupted)
+	switch {
+	case interrupted:
+		return exitInterrupted
+	case capacity == 0:
+		return exitThresholdFailed
+	}
+	return exitOK
+}
+
+// searchStep 从起始QPS开始逐级递增，直到不满足SLO或达到最大QPS
+func searchStep(o *searchOptions, runLevel func(int) *searchLevel) int {
+	capacity := 0
+	for qps := o.start; qps <= o.max; qps += o.step {
+		level := runLevel(qps)
+		if level == nil || !level.passed() {
+			break
+		}
+		capacity = qps
+	}
+	return capacity
+}
+
+// searchBinary 在起始QPS和最大QPS之间二分查找，精度为 step
+func searchBinary(o *searchOptions, runLevel func(int) *searchLevel) int {
+	level := runLevel(o.start)
+	if level == nil || !level.passed() {
+		return 0
+	}
+	level = runLevel(o.max)
+	if level == nil {
+		return o.start
+	}
+	if level.passed() {
+		return o.max
+	}
+
+	low, high := o.start, o.max // low 满足SLO，high 不满足或者客户端已饱和
+	for high-low > o.step {
+		mid := low + (high-low)/2
+		level = runLevel(mid)
+		if level == nil {
+			break
+		}
+		if level.passed() {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return low
+}
+
+// describeSLO 返回SLO的描述
+func (o *searchOptions) describeSLO() string {
+	var items []string
+	if o.sloP99 > 0 {
+		items = append(items, fmt.Sprintf("p99 < %v", o.sloP99))
+	}
+	if o.sloError >= 0 {
+		items = append(items, fmt.Sprintf("错误率 < %.2f%%", o.sloError))
+	}
+	if o.sloAchieved >= 0 {
+		items = append(items, fmt.Sprintf("完成率 >= %.1f%%", o.sloAchieved))
+	}
+	return strings.Join(items, ", ")
+}
+
+// printSearchResult 打印每级压测的结果和最终容量，interrupted 表示搜索被中断，容量只根据已完成的各级得出
+func printSearchResult(levels []*searchLevel, capacity int, interrupted bool) {
+	fmt.Printf("\n搜索结果:\n")
+	if interrupted {
+		fmt.Printf("（搜索被中断，以下只包含已完成的各级压测）\n")
+	}
+	fmt.Printf("  %10s %12s %8s %14s %10s  %s\n", "目标QPS", "实际QPS", "完成率", "p99(含排队)", "错误率", "结果")
+	for _, l := range levels {
+		result := "PASS"
+		if l.saturated {
+			result = "INVALID（客户端饱和）"
+		} else if !l.passed() {
+			result = "FAIL"
+		}
+		fmt.Printf("  %10d %12.2f %7.1f%% %14v %9.2f%%  %s\n",
+			l.target, l.achieved, l.ratio,
+			l.p99.Round(time.Microsecond), l.errRate, result)
+	}
+	if capacity > 0 && interrupted {
+		fmt.Printf("\n已完成的各级中满足SLO的最大QPS: %d（搜索被中断，实际容量可能更高）\n", capacity)
+	} else if capacity > 0 {
+		fmt.Printf("\n满足SLO的最大QPS: %d\n", capacity)
+	} else if interrupted {
+		fmt.Printf("\n搜索被中断，未找到满足SLO的QPS\n")
+	} else if len(levels) > 0 && levels[0].saturated {
+		fmt.Printf("\n起始QPS时压测客户端即已饱和，未找到满足SLO的QPS\n")
+	} else {
+		fmt.Printf("\n起始QPS即不满足SLO，未找到满足SLO的QPS\n")
+	}
+	for _, l := range levels {
+		if l.saturated {
+			fmt.Printf("\n警告：QPS %d 时压测客户端已饱和，服务端的实际容量可能更高，需要更多的客户端CPU或多台机器才能测出\n", l.target)
+			break
+		}
+	}
+}
@@ -1,8 +1,11 @@
 module github.com/panzhongxian/wrkx
 
-go 1.23.5
+go 1.24
 
-require github.com/redis/go-redis/v9 v9.8.0
+require (
+	github.com/redis/go-redis/v9 v9.8.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
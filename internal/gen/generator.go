@@ -2,6 +2,7 @@ package gen
 
 import (
 	"encoding/json"
+	"strings"
 	"time"
 )
 
@@ -10,9 +11,89 @@ type RequestGenerator interface {
 	Generate() ([]byte, error)
 }
 
+// RequestSpec 一个完整的HTTP请求
+type RequestSpec struct {
+	Method  string
+	URL     string
+	Headers map[string]string
+	Body    []byte
+}
+
+// SpecGenerator 生成完整请求的生成器接口。base 为压测参数中指定的方法、URL和头部，
+// 其中可以包含 ${变量} 占位符，生成器在 base 的基础上填充请求体并替换占位符。
+// vars 为多步骤流程中当前虚拟用户的变量，可以为nil。
+// base 和返回的 RequestSpec 中的 Headers 可能是共享的，调用方不能修改。
+type SpecGenerator interface {
+	GenerateSpec(base *RequestSpec, vars map[string]string) (*RequestSpec, error)
+}
+
+// AsSpecGenerator 将请求生成器转换为 SpecGenerator，vars 为方法、URL和头部中可以引用的变量名。
+// 只生成请求体的生成器会被自动适配，方法、URL和头部直接使用 base 中的值。
+func AsSpecGenerator(g RequestGenerator, vars ...string) SpecGenerator {
+	if sg, ok := g.(SpecGenerator); ok {
+		return sg
+	}
+	return &bodyAdapter{g: g, cache: &templateCache{vars: vars}}
+}
+
+// varsGenerator 请求体中可以引用变量的生成器
+type varsGenerator interface {
+	generate(vars map[string]string) ([]byte, error)
+}
+
+// bodyAdapter 将只生成请求体的生成器适配为 SpecGenerator
+type bodyAdapter struct {
+	g     RequestGenerator
+	cache *templateCache
+}
+
+// GenerateSpec 生成请求体，方法、URL和头部使用 base 中的值，其中的内置函数会被执行
+func (a *bodyAdapter) GenerateSpec(base *RequestSpec, vars map[string]string) (*RequestSpec, error) {
+	var body []byte
+	var err error
+	if vg, ok := a.g.(varsGenerator); ok {
+		body, err = vg.generate(vars)
+	} else {
+		body, err = a.g.Generate()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a.cache.render(base, nil, vars, body)
+}
+
+// StaticSpec 返回生成器每次都会生成的同一个请求，用于预先构造请求。只有请求体为固定字符串，
+// 并且 base 中的方法、URL和头部都没有占位符时才返回true。返回的 Headers 与 base 共享，调用方不能修改。
+func StaticSpec(g SpecGenerator, base *RequestSpec) (*RequestSpec, bool) {
+	a, ok := g.(*bodyAdapter)
+	if !ok {
+		return nil, false
+	}
+	sg, ok := a.g.(*SimpleRequestGenerator)
+	if !ok || sg.tpl != nil {
+		return nil, false
+	}
+	if strings.Contains(base.Method, "${") || strings.Contains(base.URL, "${") || hasPlaceholder(base.Headers) {
+		return nil, false
+	}
+	return &RequestSpec{Method: base.Method, URL: base.URL, Headers: base.Headers, Body: []byte(sg.req)}, true
+}
+
+// CheckTemplate 检查 text 中的占位符都能由生成器填充：内置函数和 vars 中的变量总是可用，
+// CSV列只有模板生成器才能提供
+func CheckTemplate(g RequestGenerator, text string, vars ...string) error {
+	var columns []string
+	if tg, ok := g.(*TplGenerator); ok {
+		columns = tg.headers
+	}
+	_, err := CompileTemplate(text, columns, vars)
+	return err
+}
+
 // SimpleRequestGenerator 简单请求生成器
 type SimpleRequestGenerator struct {
 	req string
+	tpl *Template // 请求体中有内置函数时使用
 }
 
 // NewSimpleRequestGenerator 创建简单请求生成器
@@ -22,10 +103,27 @@ func NewSimpleRequestGenerator(req string) *SimpleRequestGenerator {
 	}
 }
 
+// NewTemplateRequestGenerator 创建请求体中带有内置函数或变量的简单请求生成器，如 {"id": "${uuid()}"}，
+// vars 为可以引用的变量名
+func NewTemplateRequestGenerator(req string, vars ...string) (*SimpleRequestGenerator, error) {
+	tpl, err := CompileTemplate(req, nil, vars)
+	if err != nil {
+		return nil, err
+	}
+	return &SimpleRequestGenerator{req: req, tpl: tpl}, nil
+}
+
 // Generate 生成请求
 func (g *SimpleRequestGenerator) Generate() ([]byte, error) {
-	return []byte(g.req), nil
+	return g.generate(nil)
+}
 
+// generate 用当前的变量生成请求
+func (g *SimpleRequestGenerator) generate(vars map[string]string) ([]byte, error) {
+	if g.tpl != nil {
+		return []byte(g.tpl.Execute(nil, vars)), nil
+	}
+	return []byte(g.req), nil
 }
 
 // CustomRequestGenerator 自定义请求生成器
@@ -0,0 +1,466 @@
+package gen
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Template 编译后的请求模板，支持以下占位符：
+//
+//	${列名}                    替换为CSV文件中当前行该列的值
+//	${变量名}                  替换为多步骤流程中从之前的响应提取的变量，变量不存在时为空
+//	${函数名(参数1, 参数2)}      替换为内置函数的结果，见 templateFuncs
+//
+// 函数的参数可以是字面量、带引号的字符串（可以包含逗号，形如函数调用时也是字符串）、另一个函数调用或 ${...} 占位符，
+// 例如 ${md5(${user_id}-${counter()})}、${base64(uuid())}。
+type Template struct {
+	nodes []templateNode
+}
+
+// templateNode 模板中的一段，可以是字面量、CSV列、变量或函数调用
+type templateNode interface {
+	eval(b *strings.Builder, record []string, vars map[string]string)
+}
+
+type literalNode string
+
+func (n literalNode) eval(b *strings.Builder, record []string, vars map[string]string) {
+	b.WriteString(string(n))
+}
+
+// columnNode CSV文件中的一列
+type columnNode int
+
+func (n columnNode) eval(b *strings.Builder, record []string, vars map[string]string) {
+	if int(n) < len(record) {
+		b.WriteString(record[n])
+	}
+}
+
+// varNode 从之前的响应中提取的变量
+type varNode string
+
+func (n varNode) eval(b *strings.Builder, record []string, vars map[string]string) {
+	b.WriteString(vars[string(n)])
+}
+
+// callNode 一次函数调用，每个调用位置有自己的计数器
+type callNode struct {
+	fn      *templateFunc
+	args    []*Template
+	counter int64
+}
+
+func (n *callNode) eval(b *strings.Builder, record []string, vars map[string]string) {
+	args := make([]string, len(n.args))
+	for i, arg := range n.args {
+		args[i] = arg.Execute(record, vars)
+	}
+	b.WriteString(n.fn.call(n, args))
+}
+
+// templateFunc 一个内置函数，check 在编译时检查参数个数之外的约束，只检查不含占位符的参数
+type templateFunc struct {
+	minArgs, maxArgs int // maxArgs 为-1时参数个数不限
+	check            func(args []string) error
+	call             func(n *callNode, args []string) string
+}
+
+// templateFuncs 所有内置函数
+var templateFuncs = map[string]*templateFunc{
+	// randInt(min, max) 闭区间内的随机整数
+	"randInt": {minArgs: 2, maxArgs: 2, check: checkInts, call: func(n *callNode, args []string) string {
+		low, _ := strconv.ParseInt(args[0], 10, 64)
+		high, _ := strconv.ParseInt(args[1], 10, 64)
+		if high < low {
+			return strconv.FormatInt(low, 10)
+		}
+		// 区间的宽度按无符号数计算，min 和 max 相差超过 MaxInt64 时也不会溢出
+		span := uint64(high) - uint64(low)
+		if span == math.MaxUint64 {
+			return strconv.FormatInt(int64(rand.Uint64()), 10)
+		}
+		return strconv.FormatInt(low+int64(rand.Uint64N(span+1)), 10)
+	}},
+	// randString(n[, 字符集]) 长度为n的随机字符串，默认由大小写字母和数字组成
+	"randString": {minArgs: 1, maxArgs: 2, check: checkRandString, call: func(n *callNode, args []string) string {
+		// 长度来自占位符时只能在运行时检查，不合法的长度按0处理
+		length, err := strconv.Atoi(args[0])
+		if err != nil || length < 0 {
+			return ""
+		}
+		charset := []rune(alphanumeric)
+		if len(args) > 1 && args[1] != "" {
+			charset = []rune(args[1])
+		}
+		buf := make([]rune, length)
+		for i := range buf {
+			buf[i] = charset[rand.IntN(len(charset))]
+		}
+		return string(buf)
+	}},
+	// uuid() 随机的UUID（第4版）
+	"uuid": {call: func(n *callNode, args []string) string {
+		var u [16]byte
+		for i := 0; i < 16; i += 8 {
+			v := rand.Uint64()
+			for j := 0; j < 8; j++ {
+				u[i+j] = byte(v >> (8 * j))
+			}
+		}
+		u[6] = u[6]&0x0f | 0x40
+		u[8] = u[8]&0x3f | 0x80
+		h := hex.EncodeToString(u[:])
+		return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+	}},
+	// counter([起始值]) 单调递增的计数器，默认从1开始，模板中每个调用位置各自计数
+	"counter": {maxArgs: 1, check: checkInts, call: func(n *callNode, args []string) string {
+		start := int64(1)
+		if len(args) > 0 {
+			start, _ = strconv.ParseInt(args[0], 10, 64)
+		}
+		return strconv.FormatInt(start+atomic.AddInt64(&n.counter, 1)-1, 10)
+	}},
+	// now([格式]) 当前时间，格式为 unix（默认）、unix_ms、unix_us、unix_ns、rfc3339 或Go的时间格式如 2006-01-02
+	"now": {maxArgs: 1, call: func(n *callNode, args []string) string {
+		now := time.Now()
+		format := "unix"
+		if len(args) > 0 && args[0] != "" {
+			format = args[0]
+		}
+		switch format {
+		case "unix":
+			return strconv.FormatInt(now.Unix(), 10)
+		case "unix_ms":
+			return strconv.FormatInt(now.UnixMilli(), 10)
+		case "unix_us":
+			return strconv.FormatInt(now.UnixMicro(), 10)
+		case "unix_ns":
+			return strconv.FormatInt(now.UnixNano(), 10)
+		case "rfc3339":
+			return now.Format(time.RFC3339)
+		}
+		return now.Format(format)
+	}},
+	// pick(a, b, ...) 从参数中随机选择一个
+	"pick": {minArgs: 1, maxArgs: -1, call: func(n *callNode, args []string) string {
+		return args[rand.IntN(len(args))]
+	}},
+	// base64(s)、hex(s) 编码，md5(s)、sha256(s) 十六进制的摘要
+	"base64": {minArgs: 1, maxArgs: 1, call: func(n *callNode, args []string) string {
+		return base64.StdEncoding.EncodeToString([]byte(args[0]))
+	}},
+	"hex": {minArgs: 1, maxArgs: 1, call: func(n *callNode, args []string) string {
+		return hex.EncodeToString([]byte(args[0]))
+	}},
+	"md5": {minArgs: 1, maxArgs: 1, call: func(n *callNode, args []string) string {
+		sum := md5.Sum([]byte(args[0]))
+		return hex.EncodeToString(sum[:])
+	}},
+	"sha256": {minArgs: 1, maxArgs: 1, call: func(n *callNode, args []string) string {
+		sum := sha256.Sum256([]byte(args[0]))
+		return hex.EncodeToString(sum[:])
+	}},
+}
+
+const alphanumeric = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// checkInts 检查不含占位符的参数都是整数
+func checkInts(args []string) error {
+	for _, arg := range args {
+		if strings.Contains(arg, "${") {
+			continue
+		}
+		if _, err := strconv.ParseInt(arg, 10, 64); err != nil {
+			return fmt.Errorf("argument %q is not an integer", arg)
+		}
+	}
+	return nil
+}
+
+// checkRandString 检查 randString 的长度是非负整数，字符集不为空，含占位符的参数不检查
+func checkRandString(args []string) error {
+	if !strings.Contains(args[0], "${") {
+		length, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("argument %q is not an integer", args[0])
+		}
+		if length < 0 {
+			return fmt.Errorf("length %d is negative", length)
+		}
+	}
+	if len(args) > 1 && args[1] == "" {
+		return fmt.Errorf("charset is empty")
+	}
+	return nil
+}
+
+var (
+	identRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	callRe  = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\((.*)\)$`)
+)
+
+// CompileTemplate 编译模板，columns 为CSV文件的表头，没有CSV文件时为nil，vars 为可以引用的变量名。
+// 同名时CSV列优先。引用了不存在的列、变量或函数，或者参数不合法时返回错误。
+func CompileTemplate(text string, columns, vars []string) (*Template, error) {
+	t := &Template{}
+	for {
+		start := strings.Index(text, "${")
+		if start < 0 {
+			break
+		}
+		end := matchBrace(text, start+2)
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in template: %s", text[start:])
+		}
+		if start > 0 {
+			t.nodes = append(t.nodes, literalNode(text[:start]))
+		}
+		node, err := compileExpr(strings.TrimSpace(text[start+2:end]), columns, vars)
+		if err != nil {
+			return nil, err
+		}
+		t.nodes = append(t.nodes, node)
+		text = text[end+1:]
+	}
+	if text != "" {
+		t.nodes = append(t.nodes, literalNode(text))
+	}
+	return t, nil
+}
+
+// matchBrace 返回与 ${ 匹配的 } 的位置，from 为 ${ 之后的位置
+func matchBrace(text string, from int) int {
+	depth := 1
+	for i := from; i < len(text); i++ {
+		switch text[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// compileExpr 编译占位符中的表达式，为列名、变量名或函数调用
+func compileExpr(expr string, columns, vars []string) (templateNode, error) {
+	if m := callRe.FindStringSubmatch(expr); m != nil {
+		return compileCall(m[1], m[2], columns, vars)
+	}
+	if !identRe.MatchString(expr) {
+		return nil, fmt.Errorf("invalid template expression ${%s}", expr)
+	}
+	for i, column := range columns {
+		if column == expr {
+			return columnNode(i), nil
+		}
+	}
+	for _, v := range vars {
+		if v == expr {
+			return varNode(expr), nil
+		}
+	}
+	if columns == nil {
+		return nil, fmt.Errorf("template variable ${%s} requires a CSV file", expr)
+	}
+	return nil, fmt.Errorf("template variables not found in CSV headers: [%s]", expr)
+}
+
+// compileCall 编译一次函数调用
+func compileCall(name, argText string, columns, vars []string) (templateNode, error) {
+	fn, ok := templateFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown template function %s", name)
+	}
+
+	rawArgs, quoted, err := splitArgs(argText)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if len(rawArgs) < fn.minArgs || (fn.maxArgs >= 0 && len(rawArgs) > fn.maxArgs) {
+		return nil, fmt.Errorf("wrong number of arguments for %s: %d", name, len(rawArgs))
+	}
+	if fn.check != nil {
+		if err := fn.check(rawArgs); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+
+	n := &callNode{fn: fn, args: make([]*Template, len(rawArgs))}
+	for i, arg := range rawArgs {
+		// 参数本身是函数调用时，按 ${...} 处理。带引号的参数是字面量，形如函数调用也不处理
+		if !quoted[i] && callRe.MatchString(arg) {
+			arg = "${" + arg + "}"
+		}
+		if n.args[i], err = CompileTemplate(arg, columns, vars); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// splitArgs 按顶层的逗号分割参数，忽略括号、${...} 和引号中的逗号，并去掉参数两端的空白和引号。
+// quoted 与 args 一一对应，表示该参数是否带有引号
+func splitArgs(text string) (args []string, quoted []bool, err error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil, nil
+	}
+
+	add := func(arg string) {
+		arg, q := unquote(arg)
+		args = append(args, arg)
+		quoted = append(quoted, q)
+	}
+	depth := 0
+	var quote byte
+	start := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '{':
+			depth++
+		case c == ')' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			add(text[start:i])
+			start = i + 1
+		}
+	}
+	if quote != 0 || depth != 0 {
+		return nil, nil, fmt.Errorf("unbalanced quotes or parentheses in %q", text)
+	}
+	add(text[start:])
+	return args, quoted, nil
+}
+
+// unquote 去掉参数两端的空白，以及成对的单引号或双引号，返回是否去掉了引号
+func unquote(arg string) (string, bool) {
+	arg = strings.TrimSpace(arg)
+	if len(arg) >= 2 && (arg[0] == '"' || arg[0] == '\'') && arg[len(arg)-1] == arg[0] {
+		return arg[1 : len(arg)-1], true
+	}
+	return arg, false
+}
+
+// Static 判断模板中是否没有占位符
+func (t *Template) Static() bool {
+	for _, node := range t.nodes {
+		if _, ok := node.(literalNode); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Execute 用CSV文件中的一行数据和当前的变量执行模板，没有CSV文件时 record 为nil
+func (t *Template) Execute(record []string, vars map[string]string) string {
+	if len(t.nodes) == 1 {
+		if lit, ok := t.nodes[0].(literalNode); ok {
+			return string(lit)
+		}
+	}
+	var b strings.Builder
+	for _, node := range t.nodes {
+		node.eval(&b, record, vars)
+	}
+	return b.String()
+}
+
+// TemplateColumns 返回模板中引用的不属于 vars 的名字，即CSV列名，用于判断是否需要读取CSV文件
+func TemplateColumns(text string, vars []string) []string {
+	var names []string
+	for _, m := range columnRefRe.FindAllStringSubmatch(text, -1) {
+		if !slices.Contains(vars, m[1]) {
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+var columnRefRe = regexp.MustCompile(`\$\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}`)
+
+// templateCache 缓存编译后的方法、URL和头部模板，这些模板由调用方传入，每个请求都会用到
+type templateCache struct {
+	columns   []string
+	vars      []string
+	templates sync.Map // string -> *Template
+}
+
+// get 返回编译后的模板
+func (c *templateCache) get(text string) (*Template, error) {
+	if v, ok := c.templates.Load(text); ok {
+		return v.(*Template), nil
+	}
+	t, err := CompileTemplate(text, c.columns, c.vars)
+	if err != nil {
+		return nil, err
+	}
+	v, _ := c.templates.LoadOrStore(text, t)
+	return v.(*Template), nil
+}
+
+// render 执行 base 中方法、URL和头部的模板，body 为生成好的请求体
+func (c *templateCache) render(base *RequestSpec, record []string, vars map[string]string, body []byte) (*RequestSpec, error) {
+	spec := &RequestSpec{Headers: base.Headers, Body: body}
+	var err error
+	if spec.Method, err = c.execute(base.Method, record, vars); err != nil {
+		return nil, err
+	}
+	if spec.URL, err = c.execute(base.URL, record, vars); err != nil {
+		return nil, err
+	}
+	// 头部中有占位符时才复制一份，避免每个请求都分配新的map
+	if hasPlaceholder(base.Headers) {
+		spec.Headers = make(map[string]string, len(base.Headers))
+		for key, value := range base.Headers {
+			if spec.Headers[key], err = c.execute(value, record, vars); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return spec, nil
+}
+
+// execute 执行一段可能包含占位符的文本
+func (c *templateCache) execute(text string, record []string, vars map[string]string) (string, error) {
+	if !strings.Contains(text, "${") {
+		return text, nil
+	}
+	t, err := c.get(text)
+	if err != nil {
+		return "", err
+	}
+	return t.Execute(record, vars), nil
+}
+
+// hasPlaceholder 判断头部的值中是否有占位符
+func hasPlaceholder(headers map[string]string) bool {
+	for _, value := range headers {
+		if strings.Contains(value, "${") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package gen
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		text       string
+		want       []string
+		wantQuoted []bool // 为nil时不检查
+		wantErr    bool
+	}{
+		{text: "", want: nil},
+		{text: "   ", want: nil},
+		{text: "1", want: []string{"1"}},
+		{text: " 1 , 2 ", want: []string{"1", "2"}},
+		{text: `"a,b", c`, want: []string{"a,b", "c"}},
+		{text: `'x', "y"`, want: []string{"x", "y"}},
+		{text: `"f(x)", f(x), ''`, want: []string{"f(x)", "f(x)", ""}, wantQuoted: []bool{true, false, true}},
+		{text: `"it's", 1`, want: []string{"it's", "1"}},
+		{text: "md5(uuid()), 2", want: []string{"md5(uuid())", "2"}},
+		{text: "${a}-${b}, c", want: []string{"${a}-${b}", "c"}},
+		{text: "f(a, b), ${x, y}", want: []string{"f(a, b)", "${x, y}"}},
+		{text: "a,", want: []string{"a", ""}},
+		{text: `"unclosed`, wantErr: true},
+		{text: "f(a", wantErr: true},
+		{text: "${a", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, quoted, err := splitArgs(tt.text)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitArgs(%q) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !slices.Equal(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+		if len(quoted) != len(got) || (tt.wantQuoted != nil && !slices.Equal(quoted, tt.wantQuoted)) {
+			t.Errorf("splitArgs(%q) quoted = %v, want %v", tt.text, quoted, tt.wantQuoted)
+		}
+	}
+}
+
+func TestCompileTemplate(t *testing.T) {
+	columns := []string{"id", "name"}
+	vars := []string{"token"}
+	record := []string{"42", "alice"}
+	values := map[string]string{"token": "t1"}
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "", want: ""},
+		{text: "plain", want: "plain"},
+		{text: "${id}", want: "42"},
+		{text: "${ name }", want: "alice"},
+		{text: "user=${id}&token=${token}", want: "user=42&token=t1"},
+		{text: "${base64(hello)}", want: "aGVsbG8="},
+		{text: "${hex(${name})}", want: "616c696365"},
+		{text: `${md5("")}`, want: "d41d8cd98f00b204e9800998ecf8427e"},
+		{text: "${sha256(abc)}", want: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{text: "${base64(hex(${id}))}", want: "MzQzMg=="},
+		{text: "${randInt(5, 5)}", want: "5"},
+		{text: "${randInt(7, 3)}", want: "7"},
+		{text: "${pick(only)}", want: "only"},
+		{text: `${pick("a,b")}`, want: "a,b"},
+		{text: `${pick("f(x)")}`, want: "f(x)"},
+		{text: `${base64('md5(x)')}`, want: "bWQ1KHgp"},
+		{text: "${randInt(-9223372036854775808, -9223372036854775808)}", want: "-9223372036854775808"},
+		{text: "${randInt(9223372036854775807, 9223372036854775807)}", want: "9223372036854775807"},
+		{text: "${randString(0)}", want: ""},
+		{text: `${randString(3, "x")}`, want: "xxx"},
+		{text: `${randString(2, "中")}`, want: "中中"},
+	}
+	for _, tt := range tests {
+		tpl, err := CompileTemplate(tt.text, columns, vars)
+		if err != nil {
+			t.Errorf("CompileTemplate(%q) error: %v", tt.text, err)
+			continue
+		}
+		if got := tpl.Execute(record, values); got != tt.want {
+			t.Errorf("CompileTemplate(%q).Execute() = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCompileTemplateErrors(t *testing.T) {
+	tests := []struct {
+		text    string
+		columns []string
+		wantErr string
+	}{
+		{text: "${id", wantErr: "unclosed placeholder"},
+		{text: "${}", wantErr: "invalid template expression"},
+		{text: "${a-b}", wantErr: "invalid template expression"},
+		{text: "${missing}", columns: []string{"id"}, wantErr: "not found in CSV headers"},
+		{text: "${missing}", wantErr: "requires a CSV file"},
+		{text: "${nosuch()}", wantErr: "unknown template function"},
+		{text: "${randInt(1)}", wantErr: "wrong number of arguments"},
+		{text: "${randInt(1, 2, 3)}", wantErr: "wrong number of arguments"},
+		{text: "${randInt(a, 2)}", wantErr: "not an integer"},
+		{text: "${uuid(1)}", wantErr: "wrong number of arguments"},
+		{text: "${counter(x)}", wantErr: "not an integer"},
+		{text: "${pick()}", wantErr: "wrong number of arguments"},
+		{text: "${randString()}", wantErr: "wrong number of arguments"},
+		{text: "${randString(abc)}", wantErr: "not an integer"},
+		{text: "${randString(-1)}", wantErr: "negative"},
+		{text: `${randString(3, "")}`, wantErr: "charset is empty"},
+		{text: `${pick("a)}`, wantErr: "unbalanced"},
+		{text: "${md5(${missing})}", wantErr: "requires a CSV file"},
+	}
+	for _, tt := range tests {
+		_, err := CompileTemplate(tt.text, tt.columns, nil)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("CompileTemplate(%q) error = %v, want containing %q", tt.text, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRandString(t *testing.T) {
+	tpl, err := CompileTemplate(`${randString(16)}-${randString(8, "ab")}`, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(`^[A-Za-z0-9]{16}-[ab]{8}$`)
+	for i := 0; i < 100; i++ {
+		if got := tpl.Execute(nil, nil); !re.MatchString(got) {
+			t.Fatalf("Execute() = %q, want match %s", got, re)
+		}
+	}
+
+	// 长度来自变量时在运行时检查，不合法的长度得到空字符串
+	tpl, err = CompileTemplate("${randString(${n})}", nil, []string{"n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []string{"-1", "x", ""} {
+		if got := tpl.Execute(nil, map[string]string{"n": n}); got != "" {
+			t.Errorf("randString(%q) = %q, want empty", n, got)
+		}
+	}
+	if got := tpl.Execute(nil, map[string]string{"n": "5"}); len(got) != 5 {
+		t.Errorf("randString(5) = %q, want 5 characters", got)
+	}
+}
+
+func TestRandInt(t *testing.T) {
+	// min 和 max 相差超过 MaxInt64 时不能溢出
+	for _, text := range []string{
+		"${randInt(-9223372036854775808, 9223372036854775807)}",
+		"${randInt(-1, 9223372036854775807)}",
+		"${randInt(-9223372036854775808, 0)}",
+		"${randInt(-3, 3)}",
+	} {
+		tpl, err := CompileTemplate(text, nil, nil)
+		if err != nil {
+			t.Fatalf("CompileTemplate(%q) error: %v", text, err)
+		}
+		args, _, _ := splitArgs(text[len("${randInt(") : len(text)-len(")}")])
+		low, _ := strconv.ParseInt(args[0], 10, 64)
+		high, _ := strconv.ParseInt(args[1], 10, 64)
+		for i := 0; i < 100; i++ {
+			got, err := strconv.ParseInt(tpl.Execute(nil, nil), 10, 64)
+			if err != nil || got < low || got > high {
+				t.Fatalf("%s = %d (%v), want within [%d, %d]", text, got, err, low, high)
+			}
+		}
+	}
+}
+
+func TestCounter(t *testing.T) {
+	tpl, err := CompileTemplate("${counter()},${counter(100)}", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		want := strconv.Itoa(i+1) + "," + strconv.Itoa(100+i)
+		if got := tpl.Execute(nil, nil); got != want {
+			t.Errorf("Execute() #%d = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestUUID(t *testing.T) {
+	tpl, err := CompileTemplate("${uuid()}", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if got := tpl.Execute(nil, nil); !re.MatchString(got) {
+		t.Errorf("uuid() = %q, not a version 4 UUID", got)
+	}
+}
+
+func TestTemplateColumns(t *testing.T) {
+	got := TemplateColumns("${id}/${ token }/${md5(${name})}/${uuid()}", []string{"token"})
+	if want := []string{"id", "name"}; !slices.Equal(got, want) {
+		t.Errorf("TemplateColumns() = %q, want %q", got, want)
+	}
+}
+
+func TestStatic(t *testing.T) {
+	for text, want := range map[string]bool{"": true, "abc": true, "${uuid()}": false, "a${id}": false} {
+		tpl, err := CompileTemplate(text, []string{"id"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tpl.Static(); got != want {
+			t.Errorf("CompileTemplate(%q).Static() = %v, want %v", text, got, want)
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/csv"
 	"fmt"
 	"os"
-	"regexp"
 	"strings"
 	"sync/atomic"
 )
@@ -13,26 +12,16 @@ import (
 type TplGenerator struct {
 	filePaths []string
 	template  string
+	tpl       *Template // 编译后的请求体模板
 	headers   []string
 	records   [][]string
 	index     int32
+	// 方法、URL和头部中的模板
+	cache *templateCache
 }
 
-// extractTemplateVars 从模板中提取所有变量名
-func extractTemplateVars(template string) []string {
-	re := regexp.MustCompile(`\${([^}]+)}`)
-	matches := re.FindAllStringSubmatch(template, -1)
-	vars := make([]string, 0, len(matches))
-	for _, match := range matches {
-		if len(match) > 1 {
-			vars = append(vars, match[1])
-		}
-	}
-	return vars
-}
-
-// NewTplGenerator 创建一个新的模板生成器
-func NewTplGenerator(filePath string, template string) (*TplGenerator, error) {
+// NewTplGenerator 创建一个新的模板生成器，vars 为模板中可以引用的变量名
+func NewTplGenerator(filePath string, template string, vars ...string) (*TplGenerator, error) {
 	// Split file paths by comma
 	filePaths := strings.Split(filePath, ",")
 	var allRecords [][]string
@@ -104,33 +93,24 @@ func NewTplGenerator(filePath string, template string) (*TplGenerator, error) {
 	}
 
 	// Validate template variables against headers
-	templateVars := extractTemplateVars(template)
-	headerMap := make(map[string]bool)
-	for _, header := range headers {
-		headerMap[header] = true
-	}
-
-	var missingVars []string
-	for _, varName := range templateVars {
-		if !headerMap[varName] {
-			missingVars = append(missingVars, varName)
-		}
-	}
-
-	if len(missingVars) > 0 {
-		return nil, fmt.Errorf("template variables not found in CSV headers: %v", missingVars)
+	tpl, err := CompileTemplate(template, headers, vars)
+	if err != nil {
+		return nil, err
 	}
 
 	return &TplGenerator{
 		filePaths: filePaths,
 		template:  template,
+		tpl:       tpl,
 		headers:   headers,
 		records:   allRecords,
 		index:     0,
+		cache:     &templateCache{columns: headers, vars: vars},
 	}, nil
 }
 
-func (g *TplGenerator) Generate() ([]byte, error) {
+// nextRecord 按顺序取出下一行数据，到达末尾后从头开始
+func (g *TplGenerator) nextRecord() []string {
 	// 获取当前索引并递增
 	currentIndex := atomic.AddInt32((*int32)(&g.index), 1) - 1
 
@@ -140,16 +120,17 @@ func (g *TplGenerator) Generate() ([]byte, error) {
 		currentIndex = 0
 	}
 
-	record := g.records[currentIndex]
+	return g.records[currentIndex]
+}
 
+func (g *TplGenerator) Generate() ([]byte, error) {
 	// 将模板中的占位符替换为CSV中的值
-	result := g.template
-	for i, header := range g.headers {
-		if i < len(record) {
-			placeholder := fmt.Sprintf("${%s}", header)
-			result = strings.ReplaceAll(result, placeholder, record[i])
-		}
-	}
+	return []byte(g.tpl.Execute(g.nextRecord(), nil)), nil
+}
 
-	return []byte(result), nil
+// GenerateSpec 用同一行数据执行请求体模板以及 base 中方法、URL和头部的模板，
+// 例如 /users/${user_id} 和 Authorization: Bearer ${token}
+func (g *TplGenerator) GenerateSpec(base *RequestSpec, vars map[string]string) (*RequestSpec, error) {
+	record := g.nextRecord()
+	return g.cache.render(base, record, vars, []byte(g.tpl.Execute(record, vars)))
 }
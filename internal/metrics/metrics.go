@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/panzhongxian/wrkx/internal/worker"
+)
+
+// latencyBuckets 导出的延迟直方图的桶上界
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Server 以Prometheus文本格式导出压测过程中的统计数据。
+// 所有指标在每次抓取时直接从 RequestStats 读取，与最终报告的数据来源一致。
+type Server struct {
+	stats atomic.Pointer[worker.RequestStats]
+	srv   *http.Server
+}
+
+// Serve 在 addr 上启动 /metrics 接口，监听失败时立即返回错误
+func Serve(addr string) (*Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("监听指标地址 %s 失败: %v", addr, err)
+	}
+
+	s := &Server{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	s.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go s.srv.Serve(ln)
+	return s, nil
+}
+
+// SetStats 设置导出的统计数据，搜索模式下每一轮压测都会重新设置
+func (s *Server) SetStats(stats *worker.RequestStats) {
+	s.stats.Store(stats)
+}
+
+// Close 关闭 /metrics 接口
+func (s *Server) Close() error {
+	return s.srv.Close()
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	stats := s.stats.Load()
+	if stats == nil {
+		return
+	}
+	bw := bufio.NewWriter(w)
+	writeStats(bw, stats)
+	bw.Flush()
+}
+
+// writeStats 以Prometheus文本格式写出所有指标
+func writeStats(w *bufio.Writer, rs *worker.RequestStats) {
+	load := atomic.LoadInt64
+
+	// 请求结果
+	writeHeader(w, "wrkx_requests_total", "counter", "按结果统计的请求数")
+	fmt.Fprintf(w, "wrkx_requests_total{result=\"success\"} %d\n", load(&rs.TotalRequests))
+	fmt.Fprintf(w, "wrkx_requests_total{result=\"error\"} %d\n", load(&rs.FailedRequests))
+
+	writeHeader(w, "wrkx_responses_total", "counter", "按HTTP状态码统计的响应数")
+	counts := rs.StatusCounts()
+	codes := make([]int, 0, len(counts))
+	for code := range counts {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "wrkx_responses_total{code=\"%d\"} %d\n", code, counts[code])
+	}
+
+	writeHeader(w, "wrkx_errors_total", "counter", "按错误类型统计的失败请求数")
+	for _, class := range worker.ErrorClasses {
+		fmt.Fprintf(w, "wrkx_errors_total{class=\"%s\"} %d\n", class, rs.ErrorCount(class))
+	}
+
+	if failures := rs.AssertionFailures(); len(failures) > 0 {
+		writeHeader(w, "wrkx_assertion_failures_total", "counter", "各条断言失败的次数")
+		for _, f := range failures {
+			fmt.Fprintf(w, "wrkx_assertion_failures_total{assertion=\"%s\"} %d\n", escapeLabel(f.Expr), f.Count)
+		}
+	}
+
+	// 调度与发送
+	writeHeader(w, "wrkx_scheduled_requests_total", "counter", "QPS模式下调度器计划发送的请求数")
+	fmt.Fprintf(w, "wrkx_scheduled_requests_total %d\n", load(&rs.ScheduledRequests))
+	writeHeader(w, "wrkx_sent_requests_total", "counter", "实际发出的请求数")
+	fmt.Fprintf(w, "wrkx_sent_requests_total %d\n", load(&rs.SentRequests))
+	writeHeader(w, "wrkx_in_flight_requests", "gauge", "正在等待响应的请求数")
+	fmt.Fprintf(w, "wrkx_in_flight_requests %d\n", load(&rs.InFlightRequests))
+	writeHeader(w, "wrkx_target_qps", "gauge", "QPS模式下当前的目标QPS")
+	fmt.Fprintf(w, "wrkx_target_qps %d\n", load(&rs.TargetQPS))
+	writeHeader(w, "wrkx_received_bytes_total", "counter", "成功请求的响应字节数")
+	fmt.Fprintf(w, "wrkx_received_bytes_total %d\n", load(&rs.TotalBytes))
+
+	// 压测客户端自身的状态
+	if h := rs.Client; h != nil {
+		writeHeader(w, "wrkx_client_dropped_total", "counter", "在客户端丢弃的请求数，包括压测结束时仍在排队的请求")
+		fmt.Fprintf(w, "wrkx_client_dropped_total %d\n", load(&h.Dropped))
+		writeHeader(w, "wrkx_scheduler_ticks_total", "counter", "QPS模式下调度器发出的批次数")
+		fmt.Fprintf(w, "wrkx_scheduler_ticks_total %d\n", load(&h.Ticks))
+		writeHeader(w, "wrkx_scheduler_late_ticks_total", "counter", "比计划时间晚10ms以上才发出的批次数")
+		fmt.Fprintf(w, "wrkx_scheduler_late_ticks_total %d\n", load(&h.LateTicks))
+		writeHeader(w, "wrkx_client_queued_requests_total", "counter", "交给发送协程时所有发送协程都在忙的请求数")
+		fmt.Fprintf(w, "wrkx_client_queued_requests_total %d\n", load(&h.Queued))
+	}
+
+	// 延迟直方图
+	writeHistogram(w, "wrkx_latency_seconds", "成功请求的服务时间", rs.LatencyHistogram.Snapshot())
+	writeHistogram(w, "wrkx_response_time_seconds", "成功请求从计划发送时间起的响应时间（含排队）", rs.ResponseTimeHistogram.Snapshot())
+}
+
+// writeHistogram 将HDR直方图快照按 latencyBuckets 导出为Prometheus直方图
+func writeHistogram(w *bufio.Writer, name, help string, s *worker.HistogramSnapshot) {
+	writeHeader(w, name, "histogram", help)
+	for _, le := range latencyBuckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatSeconds(le), s.CountAtOrBelow(le))
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, s.Count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatSeconds(time.Duration(s.Sum)))
+	fmt.Fprintf(w, "%s_count %d\n", name, s.Count)
+}
+
+func writeHeader(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// labelEscaper 转义标签值中的反斜杠、双引号和换行
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
@@ -0,0 +1,28 @@
+package report
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"os"
+)
+
+//go:embed report.html.tmpl
+var htmlTemplateText string
+
+// htmlTemplate 单文件HTML报告的模板，图表由内嵌的JS根据报告数据绘制，不依赖任何网络资源
+var htmlTemplate = template.Must(template.New("report").Parse(htmlTemplateText))
+
+// WriteHTML 将报告以单个静态HTML文件的形式写入
+func (r *Report) WriteHTML(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("创建报告文件 %s 失败: %v", path, err)
+	}
+	defer file.Close()
+
+	if err := htmlTemplate.Execute(file, r); err != nil {
+		return fmt.Errorf("生成HTML报告失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,434 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/panzhongxian/wrkx/internal/threshold"
+	"github.com/panzhongxian/wrkx/internal/worker"
+)
+
+// SchemaVersion 报告格式的版本号。只新增字段时保持不变，修改或删除已有字段时递增。
+//
+//	2: 错误类型中去掉了 dropped，客户端丢弃的请求改为 client.dropped
+const SchemaVersion = 2
+
+// Config 压测的配置，原样写入报告
+type Config struct {
+	URL            string            `json:"url"`
+	Method         string            `json:"method"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	Mode           string            `json:"mode"` // concurrency、qps 或 stages
+	Concurrency    int               `json:"concurrency,omitempty"`
+	QPS            int               `json:"qps,omitempty"`
+	Stages         []Stage           `json:"stages,omitempty"`
+	StageMode      string            `json:"stage_mode,omitempty"`
+	Arrival        *Arrival          `json:"arrival,omitempty"`
+	DurationSec    float64           `json:"duration_sec"`
+	TimeoutSec     float64           `json:"timeout_sec"`
+	MaxWorkers     int               `json:"max_workers"`
+	SrcIP          string            `json:"src_ip,omitempty"`
+	Request        string            `json:"request,omitempty"`
+	File           string            `json:"file,omitempty"`
+	RequestTpl     string            `json:"req_template,omitempty"`
+	Thresholds     []string          `json:"thresholds,omitempty"`
+	EnableSecStats bool              `json:"enable_second_stats"`
+	MetricsAddr    string            `json:"metrics_addr,omitempty"`
+	SuccessStatus  string            `json:"success_status"`
+	Assertions     []string          `json:"assertions,omitempty"`
+	Cookies        bool              `json:"cookies"`      // 每个虚拟用户是否有自己的Cookie jar
+	VUPool         bool              `json:"vu_pool"`      // 每个虚拟用户是否使用自己的连接池
+	HTTPVersion    string            `json:"http_version"` // http1、http2 或 h2c
+	H2Conns        int               `json:"h2_conns,omitempty"`
+	H2Streams      int               `json:"h2_streams,omitempty"`
+	ConnReuse      string            `json:"conn_reuse"` // keepalive、close、max-requests:N 或 pool:N
+	TLS            TLS               `json:"tls"`
+	FastPath       bool              `json:"fast_path"` // 请求固定时是否预先构造请求
+}
+
+// TLS HTTPS请求的TLS客户端设置
+type TLS struct {
+	Cert         string `json:"cert,omitempty"`
+	CA           string `json:"ca,omitempty"`
+	ServerName   string `json:"server_name,omitempty"`
+	Insecure     bool   `json:"insecure"`
+	MinVersion   string `json:"min_version,omitempty"`
+	MaxVersion   string `json:"max_version,omitempty"`
+	CipherSuites string `json:"cipher_suites,omitempty"`
+	Resumption   bool   `json:"resumption"`
+}
+
+// Stage 负载曲线中的一个阶段
+type Stage struct {
+	DurationSec float64 `json:"duration_sec"`
+	Target      int     `json:"target_qps"`
+}
+
+// Arrival QPS模式下的到达过程
+type Arrival struct {
+	Process        string  `json:"process"`
+	BurstSize      int     `json:"burst_size,omitempty"`
+	BurstPeriodSec float64 `json:"burst_period_sec,omitempty"`
+}
+
+// Report 压测结果报告
+type Report struct {
+	SchemaVersion int                     `json:"schema_version"`
+	Tool          string                  `json:"tool"`
+	StartTime     time.Time               `json:"start_time"`
+	EndTime       time.Time               `json:"end_time"`
+	Interrupted   bool                    `json:"interrupted"` // 压测被 Ctrl-C 或 SIGTERM 中断，统计数据只包含 ElapsedSec 秒
+	ElapsedSec    float64                 `json:"elapsed_sec"` // 计算每秒请求数使用的压测时长
+	Config        Config                  `json:"config"`
+	Totals        Totals                  `json:"totals"`
+	Latency       Distribution            `json:"latency"`
+	ResponseTime  Distribution            `json:"response_time"`
+	Phases        map[string]Distribution `json:"phases"` // 各阶段的耗时分布，key 为 dns、connect、tls、ttfb、body
+	Errors        Errors                  `json:"errors"`
+	Protocols     map[string]int64        `json:"protocols"` // 按HTTP协议版本统计的响应数，key 与响应的 Proto 相同，如 HTTP/2.0
+	Connections   Connections             `json:"connections"`
+	TLSHandshakes TLSHandshakes           `json:"tls_handshakes"`
+	Client        Client                  `json:"client"`              // 压测客户端自身的状态，saturated 为true时结果无效
+	Endpoints     []Endpoint              `json:"endpoints,omitempty"` // 多接口压测时各接口的统计数据
+	Steps         []Endpoint              `json:"steps,omitempty"`     // 多步骤流程中各步骤的统计数据
+	Thresholds    []ThresholdResult       `json:"thresholds,omitempty"`
+	Series        []Second                `json:"series"`
+}
+
+// Connections 连接的统计数据
+type Connections struct {
+	Opened int64 `json:"opened"` // 新建的连接数
+	Closed int64 `json:"closed"` // 关闭的连接数，压测结束时仍然打开的连接不计入
+	Reused int64 `json:"reused"` // 复用已有连接的请求数
+}
+
+// Client 压测客户端自身的状态
+type Client struct {
+	Saturated          bool         `json:"saturated"`          // 客户端跟不上目标负载，压测结果无效
+	Problems           []string     `json:"problems,omitempty"` // 结果无效的原因
+	Warnings           []string     `json:"warnings,omitempty"` // 客户端接近极限的提示
+	Dropped            int64        `json:"dropped"`            // 在客户端丢弃的请求数，包括 abandoned
+	Abandoned          int64        `json:"abandoned"`          // 压测结束时仍在发送通道中排队、没有发出的请求数
+	SchedulerTicks     int64        `json:"scheduler_ticks"`
+	SchedulerLateTicks int64        `json:"scheduler_late_ticks"` // 比计划时间晚10ms以上才发出的批次数
+	SchedulerLag       Distribution `json:"scheduler_lag"`
+	SenderPoolSize     int64        `json:"sender_pool_size"`
+	SenderPeakBusy     int64        `json:"sender_peak_busy"`
+	QueuedRequests     int64        `json:"queued_requests"`       // 交给发送协程时所有发送协程都在忙的请求数
+	CPUPercent         *float64     `json:"cpu_percent,omitempty"` // 占全部可用CPU的百分比，当前平台不支持统计时没有该字段
+	CPUs               int          `json:"cpus"`
+}
+
+// TLSHandshakes 成功的TLS握手次数
+type TLSHandshakes struct {
+	Full    int64 `json:"full"`    // 完整握手
+	Resumed int64 `json:"resumed"` // 复用之前的会话
+}
+
+// Totals 整个压测期间的汇总数据
+type Totals struct {
+	Requests          int64   `json:"requests"`
+	FailedRequests    int64   `json:"failed_requests"`
+	TimeoutRequests   int64   `json:"timeout_requests"`
+	ScheduledRequests int64   `json:"scheduled_requests"`
+	RequestsPerSec    float64 `json:"requests_per_sec"`
+	ErrorRate         float64 `json:"error_rate"`
+	TotalBytes        int64   `json:"total_bytes"`
+}
+
+// Distribution 延迟分布，时间单位均为毫秒
+type Distribution struct {
+	Count       int64              `json:"count"`
+	MinMs       float64            `json:"min_ms"`
+	MaxMs       float64            `json:"max_ms"`
+	MeanMs      float64            `json:"mean_ms"`
+	StdDevMs    float64            `json:"stddev_ms"`
+	Percentiles map[string]float64 `json:"percentiles_ms"`
+	Buckets     []Bucket           `json:"buckets"`
+}
+
+// Bucket 延迟分布图中的一个区间
+type Bucket struct {
+	LowMs  float64 `json:"low_ms"`
+	HighMs float64 `json:"high_ms"`
+	Count  int64   `json:"count"`
+}
+
+// Errors 错误统计
+type Errors struct {
+	Total    int64            `json:"total"`
+	Timeouts int64            `json:"timeouts"`
+	ByClass  map[string]int64 `json:"by_class"`     // 按错误类型统计的失败请求数，只包含出现过的类型
+	ByStatus map[string]int64 `json:"status_codes"` // 按HTTP状态码统计的响应数，包含成功的响应
+	// 各条断言失败的次数，指定了 --assert 时才有
+	Assertions []AssertionResult `json:"assertions,omitempty"`
+}
+
+// AssertionResult 一条断言失败的次数
+type AssertionResult struct {
+	Expr     string `json:"expr"`
+	Failures int64  `json:"failures"`
+}
+
+// Endpoint 多接口压测中一个接口或多步骤流程中一个步骤的统计数据
+type Endpoint struct {
+	Name          string           `json:"name"`
+	Weight        int              `json:"weight,omitempty"` // 步骤没有权重
+	Method        string           `json:"method"`
+	URL           string           `json:"url"`
+	Totals        Totals           `json:"totals"`
+	Latency       Distribution     `json:"latency"`
+	ResponseTime  Distribution     `json:"response_time"`
+	ErrorsByClass map[string]int64 `json:"errors_by_class"`
+	StatusCodes   map[string]int64 `json:"status_codes"`
+}
+
+// ThresholdResult 一个阈值的检查结果
+type ThresholdResult struct {
+	Expr   string  `json:"expr"`
+	Metric string  `json:"metric"`
+	Actual float64 `json:"actual"`
+	NoData bool    `json:"no_data,omitempty"` // 指标没有样本，如没有成功的请求时的延迟分位数，此时 actual 为0，阈值不通过
+	Passed bool    `json:"passed"`
+}
+
+// Second 每秒的统计数据，时间单位均为毫秒
+type Second struct {
+	Timestamp     time.Time          `json:"timestamp"`
+	TargetQPS     int64              `json:"target_qps"`
+	Requests      int64              `json:"requests"`
+	Errors        int64              `json:"errors"`
+	AvgLatencyMs  float64            `json:"avg_latency_ms"`
+	P75LatencyMs  float64            `json:"p75_latency_ms"`
+	P90LatencyMs  float64            `json:"p90_latency_ms"`
+	P99LatencyMs  float64            `json:"p99_latency_ms"`
+	AvgResponseMs float64            `json:"avg_response_time_ms"`
+	P75ResponseMs float64            `json:"p75_response_time_ms"`
+	P90ResponseMs float64            `json:"p90_response_time_ms"`
+	P99ResponseMs float64            `json:"p99_response_time_ms"`
+	PhaseAvgMs    map[string]float64 `json:"phase_avg_ms"`              // 各阶段当秒的平均耗时
+	ErrorsByClass map[string]int64   `json:"errors_by_class,omitempty"` // 当秒按错误类型统计的失败请求数
+	ClientDropped int64              `json:"client_dropped"`            // 当秒在客户端丢弃的请求数
+	MaxLagMs      float64            `json:"scheduler_lag_max_ms"`      // 当秒调度器的最大延迟
+}
+
+// percentiles 报告中的分位数
+var percentiles = []struct {
+	label    string
+	quantile float64
+}{
+	{"p50", 0.50},
+	{"p75", 0.75},
+	{"p90", 0.90},
+	{"p95", 0.95},
+	{"p99", 0.99},
+	{"p99.9", 0.999},
+	{"p99.99", 0.9999},
+}
+
+// distributionBins 报告中延迟分布图的区间数
+const distributionBins = 20
+
+// ms 将时间转换为毫秒
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// New 根据压测配置、统计信息和阈值检查结果生成报告，只有一个接口时报告中不包含各接口的统计数据
+func New(cfg Config, stats *worker.RequestStats, endpoints, steps []*worker.EndpointStats, series []*worker.SecondStats, results []threshold.Result) *Report {
+	r := &Report{
+		SchemaVersion: SchemaVersion,
+		Tool:          "wrkx",
+		StartTime:     stats.StartTime,
+		EndTime:       stats.EndTime,
+		Interrupted:   stats.Interrupted,
+		ElapsedSec:    stats.Duration.Seconds(),
+		Config:        cfg,
+		Totals:        newTotals(stats),
+		Latency:       newDistribution(stats.LatencyHistogram.Snapshot()),
+		ResponseTime:  newDistribution(stats.ResponseTimeHistogram.Snapshot()),
+		Errors: Errors{
+			Total:    stats.FailedRequests,
+			Timeouts: stats.TimeoutRequests,
+			ByClass:  make(map[string]int64),
+			ByStatus: make(map[string]int64),
+		},
+		Phases:    make(map[string]Distribution, len(worker.Phases)),
+		Protocols: make(map[string]int64),
+		Series:    make([]Second, 0, len(series)),
+	}
+	for _, phase := range worker.Phases {
+		r.Phases[phase.String()] = newDistribution(stats.PhaseHistograms[phase].Snapshot())
+	}
+	for _, class := range worker.ErrorClasses {
+		if n := stats.ErrorCount(class); n > 0 {
+			r.Errors.ByClass[class.String()] = n
+		}
+	}
+	for _, f := range stats.AssertionFailures() {
+		r.Errors.Assertions = append(r.Errors.Assertions, AssertionResult{Expr: f.Expr, Failures: f.Count})
+	}
+	for code, n := range stats.StatusCounts() {
+		r.Errors.ByStatus[strconv.Itoa(code)] = n
+	}
+	r.Connections = Connections{Opened: stats.ConnsOpened, Closed: stats.ConnsClosed, Reused: stats.ConnsReused()}
+	r.TLSHandshakes.Full, r.TLSHandshakes.Resumed = stats.TLSHandshakes()
+	for _, p := range worker.Protocols {
+		if n := stats.ProtocolCount(p); n > 0 {
+			r.Protocols[p.String()] = n
+		}
+	}
+	if stats.Client != nil {
+		r.Client = newClient(stats.Client)
+	}
+
+	if len(endpoints) > 1 {
+		for _, ep := range endpoints {
+			r.Endpoints = append(r.Endpoints, newEndpoint(ep))
+		}
+	}
+	for _, step := range steps {
+		r.Steps = append(r.Steps, newEndpoint(step))
+	}
+
+	for _, res := range results {
+		r.Thresholds = append(r.Thresholds, ThresholdResult{
+			Expr:   res.Threshold.Expr,
+			Metric: res.Threshold.Metric,
+			Actual: res.Actual,
+			NoData: res.NoData,
+			Passed: res.Passed,
+		})
+	}
+
+	for _, s := range series {
+		phaseAvg := make(map[string]float64, len(worker.Phases))
+		for _, phase := range worker.Phases {
+			phaseAvg[phase.String()] = ms(s.PhaseAvg[phase])
+		}
+		var errorsByClass map[string]int64
+		for _, class := range worker.ErrorClasses {
+			if n := s.ErrorsByClass[class]; n > 0 {
+				if errorsByClass == nil {
+					errorsByClass = make(map[string]int64)
+				}
+				errorsByClass[class.String()] = n
+			}
+		}
+		r.Series = append(r.Series, Second{
+			Timestamp:     s.Timestamp,
+			TargetQPS:     s.TargetQPS,
+			Requests:      s.RequestCount,
+			Errors:        s.ErrorCount,
+			AvgLatencyMs:  ms(s.AvgLatency),
+			P75LatencyMs:  ms(s.P75Latency),
+			P90LatencyMs:  ms(s.P90Latency),
+			P99LatencyMs:  ms(s.P99Latency),
+			AvgResponseMs: ms(s.AvgResponseTime),
+			P75ResponseMs: ms(s.P75ResponseTime),
+			P90ResponseMs: ms(s.P90ResponseTime),
+			P99ResponseMs: ms(s.P99ResponseTime),
+			PhaseAvgMs:    phaseAvg,
+			ErrorsByClass: errorsByClass,
+			ClientDropped: s.Dropped,
+			MaxLagMs:      ms(s.MaxSchedulerLag),
+		})
+	}
+	return r
+}
+
+// newClient 根据客户端状态生成报告中的客户端状态
+func newClient(h *worker.ClientHealth) Client {
+	c := Client{
+		Problems:           h.Problems(),
+		Warnings:           h.Warnings(),
+		Dropped:            h.Dropped,
+		Abandoned:          h.Abandoned,
+		SchedulerTicks:     h.Ticks,
+		SchedulerLateTicks: h.LateTicks,
+		SchedulerLag:       newDistribution(h.LagHistogram.Snapshot()),
+		SenderPoolSize:     h.PoolSize,
+		SenderPeakBusy:     h.PeakBusy,
+		QueuedRequests:     h.Queued,
+		CPUs:               h.CPUs,
+	}
+	c.Saturated = len(c.Problems) > 0
+	if usage, ok := h.CPUUsage(); ok {
+		percent := usage * 100
+		c.CPUPercent = &percent
+	}
+	return c
+}
+
+// newTotals 根据统计信息生成汇总数据
+func newTotals(stats *worker.RequestStats) Totals {
+	return Totals{
+		Requests:          stats.TotalRequests,
+		FailedRequests:    stats.FailedRequests,
+		TimeoutRequests:   stats.TimeoutRequests,
+		ScheduledRequests: stats.ScheduledRequests,
+		RequestsPerSec:    stats.RequestsPerSec,
+		ErrorRate:         stats.ErrorRate(),
+		TotalBytes:        stats.TotalBytes,
+	}
+}
+
+// newEndpoint 生成一个接口或步骤的统计数据
+func newEndpoint(ep *worker.EndpointStats) Endpoint {
+	stats := ep.Stats
+	e := Endpoint{
+		Name:          ep.Name,
+		Weight:        ep.Weight,
+		Method:        ep.Method,
+		URL:           ep.URL,
+		Totals:        newTotals(stats),
+		Latency:       newDistribution(stats.LatencyHistogram.Snapshot()),
+		ResponseTime:  newDistribution(stats.ResponseTimeHistogram.Snapshot()),
+		ErrorsByClass: make(map[string]int64),
+		StatusCodes:   make(map[string]int64),
+	}
+	for _, class := range worker.ErrorClasses {
+		if n := stats.ErrorCount(class); n > 0 {
+			e.ErrorsByClass[class.String()] = n
+		}
+	}
+	for code, n := range stats.StatusCounts() {
+		e.StatusCodes[strconv.Itoa(code)] = n
+	}
+	return e
+}
+
+// newDistribution 根据直方图快照生成延迟分布
+func newDistribution(s *worker.HistogramSnapshot) Distribution {
+	d := Distribution{
+		Count:       s.Count,
+		MinMs:       ms(time.Duration(s.Min)),
+		MaxMs:       ms(time.Duration(s.Max)),
+		MeanMs:      ms(s.Mean()),
+		StdDevMs:    ms(s.StdDev()),
+		Percentiles: make(map[string]float64, len(percentiles)),
+		Buckets:     []Bucket{},
+	}
+	for _, p := range percentiles {
+		d.Percentiles[p.label] = ms(s.Quantile(p.quantile))
+	}
+	for _, bin := range s.Distribution(distributionBins) {
+		d.Buckets = append(d.Buckets, Bucket{LowMs: ms(bin.Low), HighMs: ms(bin.High), Count: bin.Count})
+	}
+	return d
+}
+
+// WriteJSON 将报告以JSON格式写入文件
+func (r *Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化报告失败: %v", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("写入报告文件 %s 失败: %v", path, err)
+	}
+	return nil
+}
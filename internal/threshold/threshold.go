@@ -0,0 +1,274 @@
+package threshold
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/panzhongxian/wrkx/internal/worker"
+)
+
+// metricKind 指标的取值类型，决定阈值的解析和展示方式
+type metricKind int
+
+const (
+	kindDuration metricKind = iota // 延迟类指标，单位为纳秒
+	kindRate                       // 比例类指标，取值0~1
+	kindNumber                     // 计数、QPS等普通数值
+)
+
+// metric 一个可以设置阈值的指标。hasData 不为nil时，返回false表示没有样本，指标没有意义
+type metric struct {
+	kind    metricKind
+	value   func(rs *worker.RequestStats) float64
+	hasData func(rs *worker.RequestStats) bool
+}
+
+// histogram 返回响应时间（含排队）或延迟的直方图
+func histogram(rs *worker.RequestStats, latency bool) *worker.Histogram {
+	if latency {
+		return rs.LatencyHistogram
+	}
+	return rs.ResponseTimeHistogram
+}
+
+// quantileMetric 返回响应时间（含排队）或延迟直方图的分位数指标
+func quantileMetric(q float64, latency bool) metric {
+	return durationMetric(latency, func(s *worker.HistogramSnapshot) time.Duration {
+		return s.Quantile(q)
+	})
+}
+
+// durationMetric 返回基于直方图快照计算的延迟类指标，没有成功的请求时没有样本
+func durationMetric(latency bool, f func(s *worker.HistogramSnapshot) time.Duration) metric {
+	return metric{
+		kind: kindDuration,
+		value: func(rs *worker.RequestStats) float64 {
+			return float64(f(histogram(rs, latency).Snapshot()))
+		},
+		hasData: func(rs *worker.RequestStats) bool {
+			return histogram(rs, latency).Snapshot().Count > 0
+		},
+	}
+}
+
+// metrics 支持的指标。不带前缀的延迟指标为从计划发送时间起计算的响应时间（含排队），
+// 带 latency_ 前缀的为服务时间，并发模式下两者基本一致。
+var metrics = map[string]metric{}
+
+func init() {
+	quantiles := map[string]float64{
+		"p50": 0.50, "p75": 0.75, "p90": 0.90, "p95": 0.95,
+		"p99": 0.99, "p99.9": 0.999, "p99.99": 0.9999,
+	}
+	for name, q := range quantiles {
+		metrics[name] = quantileMetric(q, false)
+		metrics["latency_"+name] = quantileMetric(q, true)
+	}
+
+	snapshotMetrics := map[string]func(s *worker.HistogramSnapshot) time.Duration{
+		"avg":    (*worker.HistogramSnapshot).Mean,
+		"stddev": (*worker.HistogramSnapshot).StdDev,
+		"min":    func(s *worker.HistogramSnapshot) time.Duration { return time.Duration(s.Min) },
+		"max":    func(s *worker.HistogramSnapshot) time.Duration { return time.Duration(s.Max) },
+	}
+	for name, f := range snapshotMetrics {
+		metrics[name] = durationMetric(false, f)
+		metrics["latency_"+name] = durationMetric(true, f)
+	}
+
+	metrics["error_rate"] = metric{kind: kindRate, value: func(rs *worker.RequestStats) float64 {
+		return rs.ErrorRate()
+	}}
+	metrics["rps"] = metric{kind: kindNumber, value: func(rs *worker.RequestStats) float64 {
+		return rs.RequestsPerSec
+	}}
+	metrics["requests"] = metric{kind: kindNumber, value: func(rs *worker.RequestStats) float64 {
+		return float64(rs.TotalRequests)
+	}}
+	metrics["errors"] = metric{kind: kindNumber, value: func(rs *worker.RequestStats) float64 {
+		return float64(rs.FailedRequests)
+	}}
+	metrics["timeouts"] = metric{kind: kindNumber, value: func(rs *worker.RequestStats) float64 {
+		return float64(rs.TimeoutRequests)
+	}}
+}
+
+// operators 支持的比较运算符，较长的运算符需要排在前面以便优先匹配
+var operators = []string{"<=", ">=", "==", "!=", "<", ">"}
+
+// Threshold 一个阈值表达式，如 p99<200ms、error_rate<0.1%、rps>=950
+type Threshold struct {
+	Expr   string
+	Metric string
+	Op     string
+	Value  float64
+	metric metric
+}
+
+// Parse 解析阈值表达式
+func Parse(expr string) (*Threshold, error) {
+	compact := strings.ReplaceAll(expr, " ", "")
+	for _, op := range operators {
+		idx := strings.Index(compact, op)
+		if idx < 0 {
+			continue
+		}
+		name := compact[:idx]
+		m, ok := metrics[name]
+		if !ok {
+			return nil, fmt.Errorf("阈值 %q 中的指标 %q 不存在", expr, name)
+		}
+		value, err := parseValue(compact[idx+len(op):], m.kind)
+		if err != nil {
+			return nil, fmt.Errorf("阈值 %q 的值无效: %v", expr, err)
+		}
+		if math.IsNaN(value) {
+			return nil, fmt.Errorf("阈值 %q 的值不能为 NaN", expr)
+		}
+		return &Threshold{Expr: expr, Metric: name, Op: op, Value: value, metric: m}, nil
+	}
+	return nil, fmt.Errorf("阈值 %q 缺少比较运算符", expr)
+}
+
+// parseValue 解析阈值的值：延迟类指标支持 200ms、1.5s 等写法，不带单位时视为毫秒；
+// 比例类指标支持 0.1% 或 0.001 两种写法
+func parseValue(s string, kind metricKind) (float64, error) {
+	switch kind {
+	case kindDuration:
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			return v * float64(time.Millisecond), nil
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, err
+		}
+		return float64(d), nil
+	case kindRate:
+		if strings.HasSuffix(s, "%") {
+			v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+			return v / 100, err
+		}
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// formatValue 按指标类型格式化数值
+func formatValue(v float64, kind metricKind) string {
+	switch kind {
+	case kindDuration:
+		return time.Duration(v).Round(time.Microsecond).String()
+	case kindRate:
+		return fmt.Sprintf("%.4f%%", v*100)
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// compare 判断实际值是否满足阈值
+func (t *Threshold) compare(actual float64) bool {
+	switch t.Op {
+	case "<":
+		return actual < t.Value
+	case "<=":
+		return actual <= t.Value
+	case ">":
+		return actual > t.Value
+	case ">=":
+		return actual >= t.Value
+	case "==":
+		return actual == t.Value
+	case "!=":
+		return actual != t.Value
+	}
+	return false
+}
+
+// Result 一个阈值的检查结果。NoData 表示指标没有样本（如没有成功的请求时的延迟分位数），此时阈值不通过
+type Result struct {
+	Threshold *Threshold
+	Actual    float64
+	NoData    bool
+	Passed    bool
+}
+
+// ActualString 返回格式化后的实际值，没有样本时返回 N/A
+func (r Result) ActualString() string {
+	if r.NoData {
+		return "N/A"
+	}
+	return formatValue(r.Actual, r.Threshold.metric.kind)
+}
+
+// Evaluate 根据最终的统计信息检查所有阈值
+func Evaluate(thresholds []*Threshold, stats *worker.RequestStats) []Result {
+	results := make([]Result, 0, len(thresholds))
+	for _, t := range thresholds {
+		if t.metric.hasData != nil && !t.metric.hasData(stats) {
+			results = append(results, Result{Threshold: t, NoData: true})
+			continue
+		}
+		actual := t.metric.value(stats)
+		results = append(results, Result{Threshold: t, Actual: actual, Passed: t.compare(actual)})
+	}
+	return results
+}
+
+// AllPassed 判断是否所有阈值都通过
+func AllPassed(results []Result) bool {
+	for _, r := range results {
+		if !r.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// PrintResults 以表格形式打印阈值检查结果
+func PrintResults(results []Result) {
+	if len(results) == 0 {
+		return
+	}
+
+	width := displayWidth("阈值")
+	for _, r := range results {
+		width = max(width, displayWidth(r.Threshold.Expr))
+	}
+
+	fmt.Printf("\n阈值检查:\n")
+	fmt.Printf("  %s %s  %s\n", padRight("阈值", width), padLeft("实际值", 16), "结果")
+	for _, r := range results {
+		result := "PASS"
+		if r.NoData {
+			result = "FAIL（没有样本）"
+		} else if !r.Passed {
+			result = "FAIL"
+		}
+		fmt.Printf("  %s %s  %s\n", padRight(r.Threshold.Expr, width), padLeft(r.ActualString(), 16), result)
+	}
+}
+
+// displayWidth 返回字符串在终端中的显示宽度，中日韩文字和全角符号占两列
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width++
+		if unicode.In(r, unicode.Han, unicode.Hangul, unicode.Hiragana, unicode.Katakana) ||
+			(r >= 0x3000 && r <= 0x303F) || (r >= 0xFF00 && r <= 0xFF60) || (r >= 0xFFE0 && r <= 0xFFE6) {
+			width++
+		}
+	}
+	return width
+}
+
+// padRight 在字符串右侧补空格到指定的显示宽度
+func padRight(s string, width int) string {
+	return s + strings.Repeat(" ", max(0, width-displayWidth(s)))
+}
+
+// padLeft 在字符串左侧补空格到指定的显示宽度
+func padLeft(s string, width int) string {
+	return strings.Repeat(" ", max(0, width-displayWidth(s))) + s
+}
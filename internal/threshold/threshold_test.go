@@ -0,0 +1,176 @@
+package threshold
+
+import (
+	"testing"
+	"time"
+
+	"github.com/panzhongxian/wrkx/internal/worker"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expr    string
+		metric  string
+		op      string
+		value   float64
+		wantErr bool
+	}{
+		{expr: "p99<200ms", metric: "p99", op: "<", value: float64(200 * time.Millisecond)},
+		{expr: "p99.9 <= 1.5s", metric: "p99.9", op: "<=", value: float64(1500 * time.Millisecond)},
+		{expr: "latency_p50<20", metric: "latency_p50", op: "<", value: float64(20 * time.Millisecond)},
+		{expr: "avg<0.5", metric: "avg", op: "<", value: float64(500 * time.Microsecond)},
+		{expr: "max<1m", metric: "max", op: "<", value: float64(time.Minute)},
+		{expr: "error_rate<0.1%", metric: "error_rate", op: "<", value: 0.001},
+		{expr: "error_rate<=0.001", metric: "error_rate", op: "<=", value: 0.001},
+		{expr: "rps>=950", metric: "rps", op: ">=", value: 950},
+		{expr: "requests>0", metric: "requests", op: ">", value: 0},
+		{expr: "errors==0", metric: "errors", op: "==", value: 0},
+		{expr: "timeouts!=5", metric: "timeouts", op: "!=", value: 5},
+		{expr: "", wantErr: true},
+		{expr: "p99", wantErr: true},
+		{expr: "p99 200ms", wantErr: true},
+		{expr: "p98<200ms", wantErr: true},
+		{expr: "P99<200ms", wantErr: true},
+		{expr: "<200ms", wantErr: true},
+		{expr: "p99<", wantErr: true},
+		{expr: "p99<fast", wantErr: true},
+		{expr: "p99<200xs", wantErr: true},
+		{expr: "p99<<200ms", wantErr: true},
+		{expr: "p99=<200ms", wantErr: true},
+		{expr: "p99<100ms<200ms", wantErr: true},
+		{expr: "p99<NaN", wantErr: true},
+		{expr: "error_rate<%", wantErr: true},
+		{expr: "error_rate<1%%", wantErr: true},
+		{expr: "rps>=1k", wantErr: true},
+		{expr: "rps>=nan", wantErr: true},
+	}
+	for _, tt := range tests {
+		th, err := Parse(tt.expr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (th.Metric != tt.metric || th.Op != tt.op || th.Value != tt.value || th.Expr != tt.expr) {
+			t.Errorf("Parse(%q) = %s %s %v, want %s %s %v", tt.expr, th.Metric, th.Op, th.Value, tt.metric, tt.op, tt.value)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		op     string
+		actual float64
+		want   bool
+	}{
+		{"<", 9, true}, {"<", 10, false},
+		{"<=", 10, true}, {"<=", 11, false},
+		{">", 11, true}, {">", 10, false},
+		{">=", 10, true}, {">=", 9, false},
+		{"==", 10, true}, {"==", 9, false},
+		{"!=", 9, true}, {"!=", 10, false},
+	}
+	for _, tt := range tests {
+		th := &Threshold{Op: tt.op, Value: 10}
+		if got := th.compare(tt.actual); got != tt.want {
+			t.Errorf("%v %s 10 = %v, want %v", tt.actual, tt.op, got, tt.want)
+		}
+	}
+}
+
+func mustParse(t *testing.T, exprs ...string) []*Threshold {
+	t.Helper()
+	thresholds := make([]*Threshold, len(exprs))
+	for i, expr := range exprs {
+		th, err := Parse(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		thresholds[i] = th
+	}
+	return thresholds
+}
+
+func TestEvaluate(t *testing.T) {
+	stats := worker.NewRequestStats()
+	for i := 1; i <= 100; i++ {
+		d := time.Duration(i) * time.Millisecond
+		stats.RecordSuccess(d, d, 100)
+	}
+	stats.RecordError(worker.ErrorHTTPStatus)
+	stats.RequestsPerSec = 1000
+
+	results := Evaluate(mustParse(t, "p50<=51ms", "p99<90ms", "max<1s", "error_rate<2%", "errors==0", "rps>=950"), stats)
+	want := []bool{true, false, true, true, false, true}
+	for i, r := range results {
+		if r.NoData || r.Passed != want[i] {
+			t.Errorf("%s: Actual %s, NoData %v, Passed %v, want Passed %v", r.Threshold.Expr, r.ActualString(), r.NoData, r.Passed, want[i])
+		}
+	}
+	if AllPassed(results) {
+		t.Error("AllPassed() = true with failed thresholds")
+	}
+	if !AllPassed(results[:1]) || !AllPassed(nil) {
+		t.Error("AllPassed() = false with only passed thresholds")
+	}
+}
+
+func TestEvaluateNoData(t *testing.T) {
+	// 没有成功的请求时延迟类阈值没有样本，不能视为通过
+	stats := worker.NewRequestStats()
+	stats.RecordError(worker.ErrorHTTPStatus)
+	results := Evaluate(mustParse(t, "p99<200ms", "latency_avg<1s", "min>=0", "errors<=1"), stats)
+	for _, r := range results[:3] {
+		if !r.NoData || r.Passed || r.ActualString() != "N/A" {
+			t.Errorf("%s: NoData %v, Passed %v, Actual %s, want no data and failed", r.Threshold.Expr, r.NoData, r.Passed, r.ActualString())
+		}
+	}
+	if r := results[3]; r.NoData || !r.Passed {
+		t.Errorf("%s: NoData %v, Passed %v, want passed", r.Threshold.Expr, r.NoData, r.Passed)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		v    float64
+		kind metricKind
+		want string
+	}{
+		{float64(1234567 * time.Nanosecond), kindDuration, "1.235ms"},
+		{0.00125, kindRate, "0.1250%"},
+		{950.5, kindNumber, "950.5"},
+		{0, kindNumber, "0"},
+	}
+	for _, tt := range tests {
+		if got := formatValue(tt.v, tt.kind); got != tt.want {
+			t.Errorf("formatValue(%v, %d) = %q, want %q", tt.v, tt.kind, got, tt.want)
+		}
+	}
+}
+
+func TestDisplayWidth(t *testing.T) {
+	tests := map[string]int{
+		"":            0,
+		"p99<200ms":   9,
+		"阈值":          4,
+		"实际值":         6,
+		"FAIL（没有样本）":  16,
+		"ｐ９９":         6,
+		"한국어":         6,
+		"カタカナ":        8,
+		"延迟p99<200ms": 13,
+	}
+	for s, want := range tests {
+		if got := displayWidth(s); got != want {
+			t.Errorf("displayWidth(%q) = %d, want %d", s, got, want)
+		}
+	}
+	if got := padRight("阈值", 6); got != "阈值  " {
+		t.Errorf("padRight() = %q", got)
+	}
+	if got := padLeft("N/A", 5); got != "  N/A" {
+		t.Errorf("padLeft() = %q", got)
+	}
+	if got := padRight("p99<200ms", 4); got != "p99<200ms" {
+		t.Errorf("padRight() shorter than the string = %q", got)
+	}
+}
@@ -0,0 +1,142 @@
+package worker
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// ArrivalProcess QPS模式下请求到达的分布方式
+type ArrivalProcess string
+
+const (
+	// ArrivalUniform 请求按目标速率均匀间隔发送
+	ArrivalUniform ArrivalProcess = "uniform"
+	// ArrivalPoisson 请求间隔服从指数分布，即泊松到达过程
+	ArrivalPoisson ArrivalProcess = "poisson"
+	// ArrivalBurst 请求成批同时发送，批与批之间的间隔保证平均速率等于目标速率
+	ArrivalBurst ArrivalProcess = "burst"
+)
+
+// ArrivalConfig 到达过程的配置
+type ArrivalConfig struct {
+	Process     ArrivalProcess
+	BurstSize   int           // burst模式下每批的请求数，优先于BurstPeriod
+	BurstPeriod time.Duration // burst模式下批与批之间的间隔，每批请求数由目标速率推算
+}
+
+// ParseArrivalConfig 解析并校验到达过程的配置
+func ParseArrivalConfig(process string, burstSize int, burstPeriod time.Duration) (ArrivalConfig, error) {
+	cfg := ArrivalConfig{
+		Process:     ArrivalProcess(process),
+		BurstSize:   burstSize,
+		BurstPeriod: burstPeriod,
+	}
+	switch cfg.Process {
+	case ArrivalUniform, ArrivalPoisson:
+		return cfg, nil
+	case ArrivalBurst:
+		if burstSize < 0 || burstPeriod < 0 {
+			return cfg, fmt.Errorf("burst-size 和 burst-period 不能为负数")
+		}
+		if burstSize == 0 && burstPeriod == 0 {
+			return cfg, fmt.Errorf("burst 模式下必须指定 burst-size 或 burst-period")
+		}
+		return cfg, nil
+	}
+	return cfg, fmt.Errorf("未知的到达过程 %q，可选值为 uniform、poisson 或 burst", process)
+}
+
+// String 返回到达过程的描述，用于打印压测参数
+func (c ArrivalConfig) String() string {
+	if c.Process != ArrivalBurst {
+		return string(c.Process)
+	}
+	if c.BurstSize > 0 {
+		return fmt.Sprintf("burst（每批 %d 个请求）", c.BurstSize)
+	}
+	return fmt.Sprintf("burst（每 %v 一批）", c.BurstPeriod)
+}
+
+// next 返回距下一批请求需要累积的请求额度，以及这一批的请求数
+func (c ArrivalConfig) next(rate float64) (units float64, count int) {
+	switch c.Process {
+	case ArrivalPoisson:
+		return rand.ExpFloat64(), 1
+	case ArrivalBurst:
+		n := c.BurstSize
+		if n <= 0 {
+			n = int(math.Round(rate * c.BurstPeriod.Seconds()))
+			if n < 1 {
+				n = 1
+			}
+		}
+		return float64(n), n
+	}
+	return 1, 1
+}
+
+// maxBatch 返回在给定最大速率下一批请求的最大数量，用于确定请求通道的容量
+func (c ArrivalConfig) maxBatch(maxRate int) int {
+	if c.Process != ArrivalBurst {
+		return 1
+	}
+	if c.BurstSize > 0 {
+		return c.BurstSize
+	}
+	return int(math.Ceil(float64(maxRate)*c.BurstPeriod.Seconds())) + 1
+}
+
+// arrivalStep 积分目标速率时的最大步长，负载曲线在该步长内视为恒定
+const arrivalStep = 10 * time.Millisecond
+
+// arrivalScheduler 按负载曲线和到达过程依次计算每批请求的计划发送时间。
+// 对目标速率积分得到累计的请求额度，额度每达到一次阈值就产生一批请求，
+// 均匀到达的阈值为1，泊松到达的阈值服从参数为1的指数分布。
+type arrivalScheduler struct {
+	profile *loadProfile
+	arrival ArrivalConfig
+	end     time.Duration
+	cursor  time.Duration // 已经积分到的时刻（相对于压测开始）
+	need    float64       // 距下一批请求还需累积的额度
+	count   int           // 下一批的请求数
+}
+
+func newArrivalScheduler(profile *loadProfile, arrival ArrivalConfig) *arrivalScheduler {
+	s := &arrivalScheduler{
+		profile: profile,
+		arrival: arrival,
+		end:     StagesDuration(profile.stages),
+	}
+	s.need, s.count = arrival.next(profile.rateAt(0))
+	return s
+}
+
+// next 返回下一批请求的计划发送时刻（相对于压测开始）、请求数及当时的目标速率，
+// 负载曲线结束后 ok 为 false
+func (s *arrivalScheduler) next() (offset time.Duration, count int, rate float64, ok bool) {
+	for s.cursor < s.end {
+		rate = s.profile.rateAt(s.cursor)
+		if rate <= 0 {
+			s.cursor += arrivalStep
+			continue
+		}
+		dt := time.Duration(s.need / rate * float64(time.Second))
+		if dt > arrivalStep {
+			s.cursor += arrivalStep
+			s.need -= rate * arrivalStep.Seconds()
+			continue
+		}
+		if s.cursor+dt > s.end {
+			// 下一批落在负载曲线结束之后，不再发送
+			s.cursor = s.end
+			break
+		}
+		s.cursor += dt
+		offset, count = s.cursor, s.count
+		s.need, s.count = s.arrival.next(rate)
+		return offset, count, rate, true
+	}
+	return 0, 0, 0, false
+}
@@ -0,0 +1,118 @@
+package worker
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseArrivalConfig(t *testing.T) {
+	tests := []struct {
+		process     string
+		burstSize   int
+		burstPeriod time.Duration
+		wantErr     bool
+	}{
+		{process: "uniform"},
+		{process: "poisson"},
+		{process: "uniform", burstSize: 10}, // 非burst模式忽略批参数
+		{process: "burst", burstSize: 10},
+		{process: "burst", burstPeriod: time.Second},
+		{process: "burst", burstSize: 1, burstPeriod: time.Second},
+		{process: "burst", wantErr: true},
+		{process: "burst", burstSize: -1, wantErr: true},
+		{process: "burst", burstSize: 5, burstPeriod: -time.Second, wantErr: true},
+		{process: "", wantErr: true},
+		{process: "Poisson", wantErr: true},
+		{process: "random", wantErr: true},
+	}
+	for _, tt := range tests {
+		cfg, err := ParseArrivalConfig(tt.process, tt.burstSize, tt.burstPeriod)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseArrivalConfig(%q, %d, %v) error = %v, wantErr %v", tt.process, tt.burstSize, tt.burstPeriod, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (string(cfg.Process) != tt.process || cfg.BurstSize != tt.burstSize || cfg.BurstPeriod != tt.burstPeriod) {
+			t.Errorf("ParseArrivalConfig(%q, %d, %v) = %+v", tt.process, tt.burstSize, tt.burstPeriod, cfg)
+		}
+	}
+}
+
+func TestArrivalConfigBatch(t *testing.T) {
+	tests := []struct {
+		cfg      ArrivalConfig
+		rate     float64
+		maxRate  int
+		count    int
+		maxBatch int
+	}{
+		{cfg: ArrivalConfig{Process: ArrivalUniform}, rate: 100, maxRate: 100, count: 1, maxBatch: 1},
+		{cfg: ArrivalConfig{Process: ArrivalPoisson}, rate: 100, maxRate: 100, count: 1, maxBatch: 1},
+		{cfg: ArrivalConfig{Process: ArrivalBurst, BurstSize: 50}, rate: 100, maxRate: 1000, count: 50, maxBatch: 50},
+		{cfg: ArrivalConfig{Process: ArrivalBurst, BurstPeriod: time.Second}, rate: 100, maxRate: 200, count: 100, maxBatch: 201},
+		{cfg: ArrivalConfig{Process: ArrivalBurst, BurstPeriod: time.Millisecond}, rate: 10, maxRate: 10, count: 1, maxBatch: 2},
+	}
+	for _, tt := range tests {
+		if _, count := tt.cfg.next(tt.rate); count != tt.count {
+			t.Errorf("%v next(%v) count = %d, want %d", tt.cfg, tt.rate, count, tt.count)
+		}
+		if got := tt.cfg.maxBatch(tt.maxRate); got != tt.maxBatch {
+			t.Errorf("%v maxBatch(%d) = %d, want %d", tt.cfg, tt.maxRate, got, tt.maxBatch)
+		}
+	}
+}
+
+// scheduleAll 运行调度器直到负载曲线结束，返回总请求数和最后一批的时刻
+func scheduleAll(profile *loadProfile, arrival ArrivalConfig) (total int, last time.Duration) {
+	s := newArrivalScheduler(profile, arrival)
+	for {
+		offset, count, _, ok := s.next()
+		if !ok {
+			return total, last
+		}
+		if offset < last {
+			panic("arrival offsets went backwards")
+		}
+		total += count
+		last = offset
+	}
+}
+
+func TestArrivalSchedulerTotals(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile *loadProfile
+		arrival ArrivalConfig
+		want    int
+		margin  float64 // 允许的相对误差
+	}{
+		{"uniform", constantProfile(100, 10*time.Second), ArrivalConfig{Process: ArrivalUniform}, 1000, 0.001},
+		{"burst size", constantProfile(100, 10*time.Second), ArrivalConfig{Process: ArrivalBurst, BurstSize: 20}, 1000, 0.02},
+		{"burst period", constantProfile(100, 10*time.Second), ArrivalConfig{Process: ArrivalBurst, BurstPeriod: time.Second}, 1000, 0.1},
+		{"poisson", constantProfile(1000, 10*time.Second), ArrivalConfig{Process: ArrivalPoisson}, 10000, 0.05},
+		{"ramp", &loadProfile{stages: []Stage{{10 * time.Second, 100}}, mode: StageModeRamp}, ArrivalConfig{Process: ArrivalUniform}, 500, 0.01},
+		{"zero", constantProfile(0, time.Second), ArrivalConfig{Process: ArrivalUniform}, 0, 0},
+	}
+	for _, tt := range tests {
+		total, last := scheduleAll(tt.profile, tt.arrival)
+		if math.Abs(float64(total-tt.want)) > float64(tt.want)*tt.margin {
+			t.Errorf("%s: scheduled %d requests, want %d ±%.0f%%", tt.name, total, tt.want, tt.margin*100)
+		}
+		if end := StagesDuration(tt.profile.stages); last > end {
+			t.Errorf("%s: last batch at %v, after the end %v", tt.name, last, end)
+		}
+	}
+}
+
+func TestArrivalConfigString(t *testing.T) {
+	tests := map[string]ArrivalConfig{
+		"uniform":         {Process: ArrivalUniform},
+		"burst（每批 5 个请求）": {Process: ArrivalBurst, BurstSize: 5},
+		"burst（每 2s 一批）":  {Process: ArrivalBurst, BurstPeriod: 2 * time.Second},
+	}
+	for want, cfg := range tests {
+		if got := cfg.String(); got != want {
+			t.Errorf("String() = %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// statusRange 一个闭区间的HTTP状态码范围
+type statusRange struct {
+	low, high int
+}
+
+// StatusSet 视为成功的HTTP状态码集合
+type StatusSet []statusRange
+
+// DefaultSuccessStatus 默认只有200视为成功
+var DefaultSuccessStatus = StatusSet{{200, 200}}
+
+// ParseStatusSet 解析形如 200-299,304 的状态码列表
+func ParseStatusSet(spec string) (StatusSet, error) {
+	var set StatusSet
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lowStr, highStr, isRange := strings.Cut(part, "-")
+		low, err := parseStatusCode(lowStr)
+		if err != nil {
+			return nil, err
+		}
+		high := low
+		if isRange {
+			if high, err = parseStatusCode(highStr); err != nil {
+				return nil, err
+			}
+			if high < low {
+				return nil, fmt.Errorf("无效的状态码范围 %q", part)
+			}
+		}
+		set = append(set, statusRange{low, high})
+	}
+	if len(set) == 0 {
+		return nil, fmt.Errorf("状态码列表 %q 为空", spec)
+	}
+	return set, nil
+}
+
+// parseStatusCode 解析一个100~599之间的状态码
+func parseStatusCode(s string) (int, error) {
+	code, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || code < 100 || code > maxStatusCode {
+		return 0, fmt.Errorf("无效的状态码 %q", s)
+	}
+	return code, nil
+}
+
+// Contains 判断状态码是否视为成功
+func (s StatusSet) Contains(code int) bool {
+	for _, r := range s {
+		if code >= r.low && code <= r.high {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectCodes http.Client 会自动跟随的重定向状态码
+var redirectCodes = []int{301, 302, 303, 307, 308}
+
+// HasRedirect 判断是否有重定向状态码视为成功。这时不跟随重定向，直接检查重定向响应本身
+func (s StatusSet) HasRedirect() bool {
+	for _, code := range redirectCodes {
+		if s.Contains(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// String 返回状态码列表的字符串形式
+func (s StatusSet) String() string {
+	parts := make([]string, 0, len(s))
+	for _, r := range s {
+		if r.low == r.high {
+			parts = append(parts, strconv.Itoa(r.low))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", r.low, r.high))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Assertion 对响应的一条断言，支持以下写法：
+//
+//	header:Name              响应头 Name 存在
+//	header:Name=value        响应头 Name 等于 value
+//	body-contains:text       响应体包含 text
+//	body-regex:pattern       响应体匹配正则表达式 pattern
+//	json:path=value          响应体按JSON解析后，path 处的值等于 value，path 形如 data.items[0].id
+type Assertion struct {
+	Expr  string
+	check func(resp *http.Response, body []byte) error
+}
+
+// ParseAssertion 解析一条断言
+func ParseAssertion(expr string) (*Assertion, error) {
+	kind, arg, ok := strings.Cut(expr, ":")
+	if !ok || arg == "" {
+		return nil, fmt.Errorf("无效的断言 %q，格式应为 类型:参数", expr)
+	}
+
+	a := &Assertion{Expr: expr}
+	switch kind {
+	case "header":
+		name, want, hasValue := strings.Cut(arg, "=")
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		a.check = func(resp *http.Response, body []byte) error {
+			values, ok := resp.Header[name]
+			if !ok {
+				return fmt.Errorf("响应头 %s 不存在", name)
+			}
+			if hasValue && (len(values) == 0 || values[0] != want) {
+				return fmt.Errorf("响应头 %s 为 %q", name, strings.Join(values, ","))
+			}
+			return nil
+		}
+	case "body-contains":
+		want := []byte(arg)
+		a.check = func(resp *http.Response, body []byte) error {
+			if !bytes.Contains(body, want) {
+				return fmt.Errorf("响应体不包含 %q", arg)
+			}
+			return nil
+		}
+	case "body-regex":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("断言 %q 的正则表达式无效: %v", expr, err)
+		}
+		a.check = func(resp *http.Response, body []byte) error {
+			if !re.Match(body) {
+				return fmt.Errorf("响应体不匹配 %s", arg)
+			}
+			return nil
+		}
+	case "json":
+		path, want, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("无效的断言 %q，格式应为 json:路径=值", expr)
+		}
+		keys, err := parseJSONPath(path)
+		if err != nil {
+			return nil, fmt.Errorf("断言 %q 的路径无效: %v", expr, err)
+		}
+		a.check = func(resp *http.Response, body []byte) error {
+			got, err := lookupJSON(body, keys)
+			if err != nil {
+				return err
+			}
+			if got != want {
+				return fmt.Errorf("%s 为 %s", path, got)
+			}
+			return nil
+		}
+	default:
+		return nil, fmt.Errorf("不支持的断言类型 %q", kind)
+	}
+	return a, nil
+}
+
+// Check 检查响应是否满足断言，不满足时返回原因
+func (a *Assertion) Check(resp *http.Response, body []byte) error {
+	return a.check(resp, body)
+}
+
+// parseJSONPath 将 data.items[0].id 解析为 [data items 0 id]，数组下标也可以写作 items.0
+func parseJSONPath(path string) ([]string, error) {
+	var keys []string
+	for _, part := range strings.Split(strings.TrimPrefix(path, "."), ".") {
+		name, rest, hasIndex := strings.Cut(part, "[")
+		if strings.Contains(name, "]") {
+			return nil, fmt.Errorf("路径中的方括号不匹配")
+		}
+		if name == "" && !hasIndex {
+			return nil, fmt.Errorf("路径中存在空的字段名")
+		}
+		if name != "" {
+			keys = append(keys, name)
+		}
+		// 字段名之后可以跟若干个 [下标]
+		for hasIndex {
+			index, after, ok := strings.Cut(rest, "]")
+			if !ok {
+				return nil, fmt.Errorf("路径中的方括号不匹配")
+			}
+			if _, err := strconv.ParseUint(index, 10, 0); err != nil {
+				return nil, fmt.Errorf("无效的数组下标 %q", index)
+			}
+			keys = append(keys, index)
+			if after == "" {
+				break
+			}
+			if after[0] != '[' {
+				return nil, fmt.Errorf("数组下标 [%s] 之后应为 . 或 [", index)
+			}
+			rest = after[1:]
+		}
+	}
+	return keys, nil
+}
+
+// lookupJSON 按路径取出JSON中的值。字符串返回原始内容，其他类型返回其JSON表示，如 0、true、null
+func lookupJSON(body []byte, keys []string) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return "", fmt.Errorf("响应体不是合法的JSON: %v", err)
+	}
+
+	for _, key := range keys {
+		switch node := v.(type) {
+		case map[string]any:
+			child, ok := node[key]
+			if !ok {
+				return "", fmt.Errorf("字段 %s 不存在", key)
+			}
+			v = child
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", fmt.Errorf("数组下标 %s 越界或无效", key)
+			}
+			v = node[i]
+		default:
+			return "", fmt.Errorf("字段 %s 的上级不是对象或数组", key)
+		}
+	}
+
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	data, _ := json.Marshal(v)
+	return string(data), nil
+}
@@ -0,0 +1,205 @@
+package worker
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestParseStatusSet(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    StatusSet
+		wantErr bool
+	}{
+		{spec: "200", want: StatusSet{{200, 200}}},
+		{spec: "200-299,304", want: StatusSet{{200, 299}, {304, 304}}},
+		{spec: " 200 , 201 - 204 ,", want: StatusSet{{200, 200}, {201, 204}}},
+		{spec: "100-599", want: StatusSet{{100, 599}}},
+		{spec: "204-204", want: StatusSet{{204, 204}}},
+		{spec: "", wantErr: true},
+		{spec: " , ", wantErr: true},
+		{spec: "abc", wantErr: true},
+		{spec: "99", wantErr: true},
+		{spec: "600", wantErr: true},
+		{spec: "200-600", wantErr: true},
+		{spec: "300-200", wantErr: true},
+		{spec: "200-", wantErr: true},
+		{spec: "-200", wantErr: true},
+		{spec: "200-299-304", wantErr: true},
+		{spec: "200,2xx", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseStatusSet(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseStatusSet(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !slices.Equal(got, tt.want) {
+			t.Errorf("ParseStatusSet(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestStatusSetContains(t *testing.T) {
+	set, err := ParseStatusSet("200-299,304")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for code, want := range map[int]bool{199: false, 200: true, 250: true, 299: true, 300: false, 304: true, 404: false} {
+		if got := set.Contains(code); got != want {
+			t.Errorf("Contains(%d) = %v, want %v", code, got, want)
+		}
+	}
+	if got := set.String(); got != "200-299,304" {
+		t.Errorf("String() = %q, want %q", got, "200-299,304")
+	}
+	if !DefaultSuccessStatus.Contains(200) || DefaultSuccessStatus.Contains(201) {
+		t.Errorf("DefaultSuccessStatus = %v, want only 200", DefaultSuccessStatus)
+	}
+}
+
+func TestParseAssertion(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"header",
+		"header:",
+		"body-contains:",
+		"status:200",
+		"body-regex:(",
+		"json:data.id",
+		"json:a..b=1",
+	} {
+		if _, err := ParseAssertion(expr); err == nil {
+			t.Errorf("ParseAssertion(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestAssertionCheck(t *testing.T) {
+	resp := &http.Response{Header: http.Header{"Content-Type": {"application/json"}}}
+	body := []byte(`{"code":0,"data":{"items":[{"id":"a1"}]}}`)
+	tests := []struct {
+		expr string
+		pass bool
+	}{
+		{"header:Content-Type", true},
+		{"header:content-type=application/json", true},
+		{"header:Content-Type=text/plain", false},
+		{"header:X-Missing", false},
+		{"body-contains:\"code\":0", true},
+		{"body-contains:error", false},
+		{"body-regex:\"id\":\"a\\d\"", true},
+		{"body-regex:^\\[", false},
+		{"json:code=0", true},
+		{"json:data.items[0].id=a1", true},
+		{"json:data.items.0.id=a1", true},
+		{"json:data.items[0].id=a2", false},
+		{"json:data.items[1].id=a1", false},
+		{"json:data.missing=", false},
+	}
+	for _, tt := range tests {
+		a, err := ParseAssertion(tt.expr)
+		if err != nil {
+			t.Errorf("ParseAssertion(%q) error: %v", tt.expr, err)
+			continue
+		}
+		if err := a.Check(resp, body); (err == nil) != tt.pass {
+			t.Errorf("%q Check() = %v, want pass %v", tt.expr, err, tt.pass)
+		}
+	}
+
+	a, err := ParseAssertion("json:code=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Check(resp, []byte("not json")); err == nil {
+		t.Error("json assertion passed on a non-JSON body")
+	}
+}
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    []string
+		wantErr bool
+	}{
+		{path: "code", want: []string{"code"}},
+		{path: "data.items[0].id", want: []string{"data", "items", "0", "id"}},
+		{path: "data.items.0.id", want: []string{"data", "items", "0", "id"}},
+		{path: ".data", want: []string{"data"}},
+		{path: "[0].id", want: []string{"0", "id"}},
+		{path: "matrix[1][12]", want: []string{"matrix", "1", "12"}},
+		{path: "", wantErr: true},
+		{path: ".", wantErr: true},
+		{path: "a..b", wantErr: true},
+		{path: "a.", wantErr: true},
+		{path: "a[]", wantErr: true},
+		{path: "a[0", wantErr: true},
+		{path: "a]", wantErr: true},
+		{path: "a[0]]", wantErr: true},
+		{path: "a[x]", wantErr: true},
+		{path: "a[-1]", wantErr: true},
+		{path: "a[0]b", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseJSONPath(tt.path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseJSONPath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !slices.Equal(got, tt.want) {
+			t.Errorf("parseJSONPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLookupJSON(t *testing.T) {
+	body := []byte(`{"data":{"items":[{"id":7,"name":"x"}],"ok":true,"none":null,"price":1.50,"big":12345678901234567890}}`)
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "data.items[0].id", want: "7"},
+		{path: "data.items[0].name", want: "x"},
+		{path: "data.ok", want: "true"},
+		{path: "data.none", want: "null"},
+		{path: "data.price", want: "1.50"},
+		{path: "data.big", want: "12345678901234567890"},
+		{path: "data.items", want: `[{"id":7,"name":"x"}]`},
+		{path: "data.missing", wantErr: true},
+		{path: "data.items[1]", wantErr: true},
+		{path: "data.items.first", wantErr: true},
+		{path: "data.ok.value", wantErr: true},
+	}
+	for _, tt := range tests {
+		keys, err := parseJSONPath(tt.path)
+		if err != nil {
+			t.Fatalf("parseJSONPath(%q) error: %v", tt.path, err)
+		}
+		got, err := lookupJSON(body, keys)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("lookupJSON(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("lookupJSON(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+	if _, err := lookupJSON([]byte("{"), []string{"a"}); err == nil {
+		t.Error("lookupJSON on truncated JSON succeeded, want error")
+	}
+}
+
+func TestStatusSetHasRedirect(t *testing.T) {
+	for spec, want := range map[string]bool{"200": false, "200-299,304": false, "300": false, "301": true, "302": true, "200-399": true, "307-308": true} {
+		set, err := ParseStatusSet(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := set.HasRedirect(); got != want {
+			t.Errorf("ParseStatusSet(%q).HasRedirect() = %v, want %v", spec, got, want)
+		}
+	}
+}
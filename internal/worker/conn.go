@@ -0,0 +1,125 @@
+package worker
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ConnReuseMode 连接复用的方式
+type ConnReuseMode string
+
+const (
+	ReuseKeepAlive   ConnReuseMode = "keepalive"    // 长连接，连接池中的连接可以一直复用
+	ReuseClose       ConnReuseMode = "close"        // 每个请求都带上 Connection: close，用完即关闭
+	ReuseMaxRequests ConnReuseMode = "max-requests" // 每个连接最多发送N个请求后关闭
+	ReusePool        ConnReuseMode = "pool"         // 固定N个连接，连接都被占用时请求等待空闲的连接
+)
+
+// ConnReuseConfig 连接复用设置，N 为 max-requests 的请求数或 pool 的连接数
+type ConnReuseConfig struct {
+	Mode ConnReuseMode
+	N    int
+}
+
+// ParseConnReuse 解析连接复用设置，格式为 keepalive、close、max-requests:N 或 pool:N
+func ParseConnReuse(spec string) (ConnReuseConfig, error) {
+	name, arg, hasArg := strings.Cut(spec, ":")
+	mode := ConnReuseMode(name)
+	switch mode {
+	case ReuseKeepAlive, ReuseClose:
+		if hasArg {
+			return ConnReuseConfig{}, fmt.Errorf("连接复用方式 %s 不需要参数", mode)
+		}
+		return ConnReuseConfig{Mode: mode}, nil
+	case ReuseMaxRequests, ReusePool:
+		n, err := strconv.Atoi(arg)
+		if !hasArg || err != nil || n <= 0 {
+			return ConnReuseConfig{}, fmt.Errorf("连接复用方式 %s 需要一个大于0的整数参数，如 %s:100", mode, mode)
+		}
+		return ConnReuseConfig{Mode: mode, N: n}, nil
+	}
+	return ConnReuseConfig{}, fmt.Errorf("不支持的连接复用方式 %q，可选 keepalive、close、max-requests:N、pool:N", spec)
+}
+
+// String 返回与 ParseConnReuse 的输入相同格式的字符串
+func (c ConnReuseConfig) String() string {
+	if c.N > 0 {
+		return fmt.Sprintf("%s:%d", c.Mode, c.N)
+	}
+	return string(c.Mode)
+}
+
+// applyConnReuse 按连接复用设置调整连接池，并统计连接的建立和关闭
+func (w *Worker) applyConnReuse(transport *http.Transport) {
+	switch w.connReuse.Mode {
+	case ReuseClose:
+		transport.DisableKeepAlives = true
+	case ReusePool:
+		transport.MaxConnsPerHost = w.connReuse.N
+		transport.MaxIdleConnsPerHost = w.connReuse.N
+	}
+
+	maxRequests := int64(0)
+	if w.connReuse.Mode == ReuseMaxRequests {
+		maxRequests = int64(w.connReuse.N)
+	}
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		atomic.AddInt64(&w.stats.ConnsOpened, 1)
+		return &trackedConn{Conn: conn, stats: w.stats, maxRequests: maxRequests}, nil
+	}
+}
+
+// errConnRetired 连接已经发送了 max-requests 个请求，不能再发送新的请求
+var errConnRetired = errors.New("连接已达到最大请求数")
+
+// trackedConn 统计关闭次数和发送的请求数的连接。
+// 设置了 maxRequests 时，第 maxRequests 个请求完成后关闭连接。在关闭之前被其他请求从连接池中取出时，
+// 拒绝写入任何数据，Transport 会把这种没有写出数据的失败当作可以安全重试的错误，换一个连接重新发送。
+type trackedConn struct {
+	net.Conn
+	stats       *RequestStats
+	maxRequests int64
+	requests    atomic.Int64
+	closeOnce   sync.Once
+}
+
+func (c *trackedConn) Write(b []byte) (int, error) {
+	if c.maxRequests > 0 && c.requests.Load() > c.maxRequests {
+		c.Close()
+		return 0, errConnRetired
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *trackedConn) Close() error {
+	c.closeOnce.Do(func() { atomic.AddInt64(&c.stats.ConnsClosed, 1) })
+	return c.Conn.Close()
+}
+
+// acquire 记录连接被一个请求取得，返回该请求是否是这个连接的最后一个请求
+func (c *trackedConn) acquire() bool {
+	n := c.requests.Add(1)
+	return c.maxRequests > 0 && n >= c.maxRequests
+}
+
+// asTrackedConn 从 httptrace 得到的连接中找到 trackedConn，HTTPS连接需要先取出底层的连接
+func asTrackedConn(conn net.Conn) *trackedConn {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	c, _ := conn.(*trackedConn)
+	return c
+}
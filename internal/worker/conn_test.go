@@ -0,0 +1,102 @@
+package worker
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestParseConnReuse(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    ConnReuseConfig
+		wantErr bool
+	}{
+		{spec: "keepalive", want: ConnReuseConfig{Mode: ReuseKeepAlive}},
+		{spec: "close", want: ConnReuseConfig{Mode: ReuseClose}},
+		{spec: "max-requests:100", want: ConnReuseConfig{Mode: ReuseMaxRequests, N: 100}},
+		{spec: "max-requests:1", want: ConnReuseConfig{Mode: ReuseMaxRequests, N: 1}},
+		{spec: "pool:8", want: ConnReuseConfig{Mode: ReusePool, N: 8}},
+		{spec: "", wantErr: true},
+		{spec: "KeepAlive", wantErr: true},
+		{spec: "keepalive:1", wantErr: true},
+		{spec: "close:", wantErr: true},
+		{spec: "max-requests", wantErr: true},
+		{spec: "max-requests:", wantErr: true},
+		{spec: "max-requests:0", wantErr: true},
+		{spec: "max-requests:-3", wantErr: true},
+		{spec: "pool:1.5", wantErr: true},
+		{spec: "pool:ten", wantErr: true},
+		{spec: "pool:8:9", wantErr: true},
+		{spec: "reuse:8", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseConnReuse(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseConnReuse(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseConnReuse(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+		if s := got.String(); s != tt.spec {
+			t.Errorf("ParseConnReuse(%q).String() = %q", tt.spec, s)
+		}
+	}
+}
+
+func TestTrackedConnMaxRequests(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			if _, err := server.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	stats := &RequestStats{}
+	c := &trackedConn{Conn: client, stats: stats, maxRequests: 2}
+	if last := c.acquire(); last {
+		t.Error("first of 2 requests reported as the last")
+	}
+	if _, err := c.Write([]byte("a")); err != nil {
+		t.Fatalf("Write() on first request: %v", err)
+	}
+	if last := c.acquire(); !last {
+		t.Error("second of 2 requests not reported as the last")
+	}
+	if _, err := c.Write([]byte("b")); err != nil {
+		t.Fatalf("Write() on last request: %v", err)
+	}
+
+	// 第3个请求在连接关闭前取得了连接，不能写出任何数据
+	c.acquire()
+	if n, err := c.Write([]byte("c")); n != 0 || !errors.Is(err, errConnRetired) {
+		t.Errorf("Write() after max requests = %d, %v, want 0, errConnRetired", n, err)
+	}
+	c.Close()
+	if stats.ConnsClosed != 1 {
+		t.Errorf("ConnsClosed = %d, want 1 after closing twice", stats.ConnsClosed)
+	}
+}
+
+func TestTrackedConnUnlimited(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	c := &trackedConn{Conn: client, stats: &RequestStats{}}
+	for i := 0; i < 100; i++ {
+		if c.acquire() {
+			t.Fatalf("request %d reported as the last without max-requests", i+1)
+		}
+	}
+	c.Close()
+	if c.stats.ConnsClosed != 1 {
+		t.Errorf("ConnsClosed = %d, want 1", c.stats.ConnsClosed)
+	}
+}
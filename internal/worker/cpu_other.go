@@ -0,0 +1,10 @@
+//go:build !unix
+
+package worker
+
+import "time"
+
+// ProcessCPUTime 当前平台不支持统计进程的CPU时间
+func ProcessCPUTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -92,5 +92,5 @@ func DialWithCache(ctx context.Context, network, addr string) (net.Conn, error)
 		}
 	}
 
-	return nil, fmt.Errorf("failed after %d retries, last error: %v", maxRetries, lastErr)
+	return nil, fmt.Errorf("failed after %d retries, last error: %w", maxRetries, lastErr)
 }
@@ -0,0 +1,136 @@
+package worker
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"time"
+
+	"github.com/panzhongxian/wrkx/internal/gen"
+)
+
+// Endpoint 多接口压测中的一个接口，每个请求按权重随机选择一个接口发送。
+// 也用作多步骤流程中的一个步骤，此时不使用 Weight。
+type Endpoint struct {
+	Name      string
+	Weight    int
+	Method    string
+	URL       string
+	Headers   map[string]string
+	Generator gen.RequestGenerator
+	// 从成功的响应中提取的变量，供同一虚拟用户之后的请求使用
+	Extract []*Extractor
+}
+
+// EndpointStats 一个接口及其统计信息
+type EndpointStats struct {
+	Endpoint
+	Stats *RequestStats
+	// 生成完整请求的生成器，以及其中可以包含占位符的方法、URL和头部
+	spec gen.SpecGenerator
+	base *gen.RequestSpec
+	// 请求固定时预先构造的请求，为nil时每个请求都由 spec 生成
+	template *requestTemplate
+}
+
+// endpointPicker 按权重随机选择接口
+type endpointPicker struct {
+	endpoints []*EndpointStats
+	cumWeight []int // 权重的前缀和
+}
+
+// newEndpointPicker 创建接口选择器。只有一个接口时直接使用整体的统计信息，
+// 有多个接口时每个接口单独统计，并同时累加到整体的统计信息中。
+func newEndpointPicker(endpoints []Endpoint, total *RequestStats) *endpointPicker {
+	p := &endpointPicker{}
+	vars := ExtractVars(endpoints)
+	sum := 0
+	for _, ep := range endpoints {
+		stats := total
+		if len(endpoints) > 1 {
+			stats = total.newChild()
+		}
+		sum += ep.Weight
+		p.endpoints = append(p.endpoints, newEndpointStats(ep, stats, vars))
+		p.cumWeight = append(p.cumWeight, sum)
+	}
+	return p
+}
+
+// newEndpointStats 创建一个接口的统计信息，vars 为请求中可以引用的变量名
+func newEndpointStats(ep Endpoint, stats *RequestStats, vars []string) *EndpointStats {
+	return &EndpointStats{
+		Endpoint: ep,
+		Stats:    stats,
+		spec:     gen.AsSpecGenerator(ep.Generator, vars...),
+		base:     &gen.RequestSpec{Method: ep.Method, URL: ep.URL, Headers: ep.Headers},
+	}
+}
+
+// pick 按权重随机选择一个接口
+func (p *endpointPicker) pick() *EndpointStats {
+	if len(p.endpoints) == 1 {
+		return p.endpoints[0]
+	}
+	n := rand.IntN(p.cumWeight[len(p.cumWeight)-1])
+	return p.endpoints[sort.SearchInts(p.cumWeight, n+1)]
+}
+
+// finishEndpoints 压测结束后计算各接口的每秒请求数
+func finishEndpoints(endpoints []*EndpointStats, start, end time.Time, duration time.Duration) {
+	for _, ep := range endpoints {
+		ep.Stats.StartTime = start
+		ep.Stats.EndTime = end
+		ep.Stats.RequestsPerSec = float64(ep.Stats.TotalRequests) / duration.Seconds()
+	}
+}
+
+// PrintEndpointStats 以表格形式打印各接口的统计信息，只有一个接口时不打印
+func PrintEndpointStats(endpoints []*EndpointStats) {
+	if len(endpoints) <= 1 {
+		return
+	}
+
+	totalWeight := 0
+	for _, ep := range endpoints {
+		totalWeight += ep.Weight
+	}
+
+	fmt.Printf("\n各接口统计:\n")
+	fmt.Printf("  %-24s %6s %10s %10s %8s %10s %12s %12s %12s\n",
+		"接口", "权重", "成功", "失败", "错误率", "QPS", "p50", "p90", "p99")
+	for _, ep := range endpoints {
+		printEndpointRow(ep, fmt.Sprintf("%5.1f%%", float64(ep.Weight)*100/float64(totalWeight)))
+	}
+	fmt.Printf("  （分位数为响应时间，含排队）\n")
+}
+
+// PrintStepStats 以表格形式打印多步骤流程中各步骤的统计信息
+func PrintStepStats(steps []*EndpointStats) {
+	if len(steps) == 0 {
+		return
+	}
+
+	fmt.Printf("\n各步骤统计:\n")
+	fmt.Printf("  %-24s %6s %10s %10s %8s %10s %12s %12s %12s\n",
+		"步骤", "序号", "成功", "失败", "错误率", "QPS", "p50", "p90", "p99")
+	for i, step := range steps {
+		printEndpointRow(step, fmt.Sprintf("%d", i+1))
+	}
+	fmt.Printf("  （分位数为响应时间，第一步含排队）\n")
+}
+
+// printEndpointRow 打印一个接口或步骤的统计信息，column 为第二列的内容
+func printEndpointRow(ep *EndpointStats, column string) {
+	rs := ep.Stats
+	s := rs.ResponseTimeHistogram.Snapshot()
+	if s.Count == 0 {
+		fmt.Printf("  %-24s %6s %10d %10d %7.2f%% %10.2f %12s %12s %12s\n",
+			ep.Name, column, rs.TotalRequests, rs.FailedRequests, rs.ErrorRate()*100, rs.RequestsPerSec, "-", "-", "-")
+		return
+	}
+	fmt.Printf("  %-24s %6s %10d %10d %7.2f%% %10.2f %12v %12v %12v\n",
+		ep.Name, column, rs.TotalRequests, rs.FailedRequests, rs.ErrorRate()*100, rs.RequestsPerSec,
+		s.Quantile(0.50).Round(time.Microsecond), s.Quantile(0.90).Round(time.Microsecond),
+		s.Quantile(0.99).Round(time.Microsecond))
+}
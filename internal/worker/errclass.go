@@ -0,0 +1,152 @@
+package worker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ErrorClass 失败请求的错误类型
+type ErrorClass int
+
+const (
+	ErrorHTTPStatus     ErrorClass = iota // 响应状态码不是成功状态码，具体状态码见 StatusCounts
+	ErrorConnRefused                      // 连接被拒绝
+	ErrorConnReset                        // 连接被重置或被对端提前关闭
+	ErrorDNS                              // DNS解析失败
+	ErrorTimeoutConnect                   // 建立连接（含等待空闲连接）时超时
+	ErrorTimeoutTLS                       // TLS握手时超时
+	ErrorTimeoutTTFB                      // 发送请求后等待响应时超时
+	ErrorTimeoutBody                      // 读取响应体时超时
+	ErrorTLS                              // TLS握手或证书校验失败
+	ErrorGenerator                        // 请求生成器或构造请求失败
+	ErrorOther                            // 其他错误
+	ErrorAssertion                        // 响应不满足断言，具体断言见 AssertionFailures
+	ErrorExtract                          // 多步骤流程中无法从响应中提取变量
+	errorClassCount
+)
+
+// ErrorClasses 所有错误类型
+var ErrorClasses = []ErrorClass{
+	ErrorHTTPStatus, ErrorConnRefused, ErrorConnReset, ErrorDNS,
+	ErrorTimeoutConnect, ErrorTimeoutTLS, ErrorTimeoutTTFB, ErrorTimeoutBody,
+	ErrorTLS, ErrorGenerator, ErrorOther, ErrorAssertion, ErrorExtract,
+}
+
+var errorClassNames = [errorClassCount]string{
+	"http_status", "conn_refused", "conn_reset", "dns",
+	"timeout_connect", "timeout_tls", "timeout_ttfb", "timeout_body",
+	"tls", "generator", "other", "assertion", "extract",
+}
+
+var errorClassLabels = [errorClassCount]string{
+	"HTTP状态码", "连接被拒绝", "连接被重置", "DNS解析失败",
+	"建立连接超时", "TLS握手超时", "等待响应超时", "读取响应体超时",
+	"TLS错误", "生成请求失败", "其他错误", "断言失败", "提取变量失败",
+}
+
+// String 返回错误类型的英文名，用于CSV、JSON报告和指标
+func (c ErrorClass) String() string {
+	return errorClassNames[c]
+}
+
+// Label 返回错误类型的中文名，用于终端输出
+func (c ErrorClass) Label() string {
+	return errorClassLabels[c]
+}
+
+// IsTimeout 判断是否为超时错误
+func (c ErrorClass) IsTimeout() bool {
+	return c >= ErrorTimeoutConnect && c <= ErrorTimeoutBody
+}
+
+// classifyError 根据 client.Do 返回的错误和请求的阶段记录判断错误类型
+func classifyError(err error, trace *requestTrace) ErrorClass {
+	if isTimeout(err) {
+		switch {
+		case atomic.LoadInt64(&trace.gotConn) != 0:
+			return ErrorTimeoutTTFB
+		case atomic.LoadInt64(&trace.tlsStart) != 0 && atomic.LoadInt64(&trace.tlsDone) == 0:
+			return ErrorTimeoutTLS
+		default:
+			return ErrorTimeoutConnect
+		}
+	}
+
+	var dnsErr *net.DNSError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var certErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorConnRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrorConnReset
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &certErr),
+		errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return ErrorTLS
+	case atomic.LoadInt64(&trace.tlsStart) != 0 && atomic.LoadInt64(&trace.tlsDone) == 0:
+		return ErrorTLS
+	}
+	return ErrorOther
+}
+
+// classifyBodyError 判断读取响应体时的错误类型
+func classifyBodyError(err error) ErrorClass {
+	if isTimeout(err) {
+		return ErrorTimeoutBody
+	}
+	return ErrorConnReset
+}
+
+// isTimeout 判断是否为超时错误
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// errorSampler 按错误类型限流打印失败请求的样例，避免高QPS下的错误刷屏拖慢压测本身。
+// 每种错误类型每个周期最多打印一条，并附带期间未打印的同类错误数。
+type errorSampler struct {
+	interval   time.Duration
+	last       [errorClassCount]int64
+	suppressed [errorClassCount]int64
+}
+
+// newErrorSampler 创建一个错误样例打印器
+func newErrorSampler(interval time.Duration) *errorSampler {
+	return &errorSampler{interval: interval}
+}
+
+// log 打印一条错误样例，同类错误在一个周期内只打印第一条
+func (s *errorSampler) log(class ErrorClass, format string, args ...any) {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&s.last[class])
+	if now-last < int64(s.interval) || !atomic.CompareAndSwapInt64(&s.last[class], last, now) {
+		atomic.AddInt64(&s.suppressed[class], 1)
+		return
+	}
+
+	msg := fmt.Sprintf(format, args...)
+	if n := atomic.SwapInt64(&s.suppressed[class], 0); n > 0 {
+		fmt.Printf("请求失败 [%s]: %s（期间另有 %d 个同类错误未打印）\n", class, msg, n)
+	} else {
+		fmt.Printf("请求失败 [%s]: %s\n", class, msg)
+	}
+}
@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/panzhongxian/wrkx/internal/gen"
+)
+
+// requestTemplate 预先构造好的请求，请求体、方法、URL和头部都固定时使用。
+// 每个请求只浅复制一次 http.Request，不再生成请求体、解析URL和设置头部。
+type requestTemplate struct {
+	spec    *gen.RequestSpec
+	req     *http.Request                 // 不能修改，头部和URL被所有请求共享
+	getBody func() (io.ReadCloser, error) // 即 newBody，预先取得方法值，避免每个请求分配一次
+}
+
+// newRequestTemplate 按固定的请求创建请求模板，头部的设置方式与逐个构造请求时相同
+func newRequestTemplate(spec *gen.RequestSpec) (*requestTemplate, error) {
+	req, err := http.NewRequest(spec.Method, spec.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range spec.Headers {
+		req.Header.Set(key, value)
+	}
+	req.ContentLength = int64(len(spec.Body))
+	if len(spec.Body) == 0 {
+		req.Body = http.NoBody
+	}
+	t := &requestTemplate{spec: spec, req: req}
+	t.getBody = t.newBody
+	return t, nil
+}
+
+// newRequest 创建一个请求。Client 会把 jar 中的Cookie加到请求的头部中，
+// 这时复制一份头部，否则所有请求共用模板的头部。
+func (t *requestTemplate) newRequest(ctx context.Context, jar http.CookieJar) *http.Request {
+	req := t.req.WithContext(ctx)
+	if jar != nil && len(jar.Cookies(req.URL)) > 0 {
+		req.Header = t.req.Header.Clone()
+	}
+	if len(t.spec.Body) > 0 {
+		// 连接被复用前关闭等情况下 Transport 会用 GetBody 重新发送请求体。
+		// 请求体的 Reader 不放入对象池，Transport 可能在关闭请求体之后仍在另一个协程中读取它。
+		req.Body, _ = t.newBody()
+		req.GetBody = t.getBody
+	}
+	return req
+}
+
+// newBody 返回读取请求体的 Reader
+func (t *requestTemplate) newBody() (io.ReadCloser, error) {
+	body := &bodyReader{}
+	body.Reset(t.spec.Body)
+	return body, nil
+}
+
+// bodyReader 读取固定请求体，关闭时什么都不做，与 io.NopCloser(bytes.NewReader(b)) 相同但只分配一次
+type bodyReader struct {
+	bytes.Reader
+}
+
+func (*bodyReader) Close() error { return nil }
+
+// beginRequest 返回快速路径下发送一个请求使用的带超时的 context 和记录各阶段耗时的 requestTrace，
+// 请求结束后需要调用 endRequest。每个请求有自己的 requestTrace，创建 requestTrace 和 httptrace 回调的开销较大，
+// 上一个请求的 requestTrace 确定不会再有回调时留给下一个请求复用。
+func (s *session) beginRequest(parent context.Context, stats *RequestStats, timeout time.Duration) (context.Context, context.CancelFunc, *requestTrace) {
+	trace := s.trace
+	s.trace = nil
+	if trace == nil {
+		trace = newRequestTrace(stats)
+		trace.ctx = httptrace.WithClientTrace(parent, trace.clientTrace())
+	} else {
+		trace.reset(stats)
+	}
+	ctx, cancel := context.WithTimeout(trace.ctx, timeout)
+	return ctx, cancel, trace
+}
+
+// endRequest 取消请求的 context。reusable 为请求成功并且使用的是HTTP/1连接，
+// 这时如果请求直接取得了空闲连接，Transport 没有为它拨号，requestTrace 可以给下一个请求复用。
+// Transport 为请求发起的拨号在请求结束后仍会继续（例如请求先取得了别的请求释放的连接），
+// 之后的DNS解析、TCP连接和TLS握手回调仍会写入该请求的 requestTrace，这样的 requestTrace 不再复用。
+// HTTP/2 的 GotConn 无法区分这种情况，也不复用。
+func (s *session) endRequest(cancel context.CancelFunc, trace *requestTrace, reusable bool) {
+	cancel()
+	if reusable && trace.settled() {
+		s.trace = trace
+	}
+}
+
+// prepareTemplates 为请求固定的接口预先构造请求，无法构造的接口仍按原来的方式逐个生成请求，以便记录错误
+func prepareTemplates(endpoints []*EndpointStats) {
+	for _, ep := range endpoints {
+		spec, ok := gen.StaticSpec(ep.spec, ep.base)
+		if !ok {
+			continue
+		}
+		if t, err := newRequestTemplate(spec); err == nil {
+			ep.template = t
+		}
+	}
+}
+
+// maxPooledBuffer 放回对象池的响应体缓冲区的最大容量，避免偶尔的大响应长期占用内存
+const maxPooledBuffer = 1 << 20
+
+// bufferPool 读取响应体的缓冲区
+var bufferPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// readBody 将响应体读入对象池中的缓冲区，用完后调用 putBuffer 放回
+func readBody(r io.Reader) (*bytes.Buffer, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	_, err := buf.ReadFrom(r)
+	return buf, err
+}
+
+// putBuffer 将缓冲区放回对象池，之后不能再使用从中得到的响应体
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
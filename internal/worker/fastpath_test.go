@@ -0,0 +1,248 @@
+package worker
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+	"time"
+
+	"github.com/panzhongxian/wrkx/internal/gen"
+)
+
+// newTestWorker 创建一个向 url 发送固定请求的 Worker，并完成 Start 中发送请求前的准备
+func newTestWorker(t *testing.T, url string, timeout time.Duration, fastPath bool) *Worker {
+	t.Helper()
+	w := NewWorker(url, 1, time.Second, timeout, 0, gen.NewSimpleRequestGenerator(`{"hello":"world"}`), false, "POST", "X-Test:1", "")
+	if w == nil {
+		t.Fatal("NewWorker failed")
+	}
+	w.SetFastPath(fastPath)
+	w.client = w.newClient(w.createRoundTripper())
+	w.picker = newEndpointPicker([]Endpoint{{Name: "default", Weight: 1, Method: w.method, URL: w.url, Headers: w.headers, Generator: w.generator}}, w.stats)
+	if fastPath {
+		prepareTemplates(w.GetEndpointStats())
+	}
+	return w
+}
+
+func TestSendTimeouts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if body, _ := io.ReadAll(r.Body); string(body) != `{"hello":"world"}` || r.Header.Get("X-Test") != "1" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Query().Get("delay") {
+		case "header":
+			time.Sleep(200 * time.Millisecond)
+		case "body":
+			rw.Write([]byte("partial"))
+			rw.(http.Flusher).Flush()
+			time.Sleep(200 * time.Millisecond)
+		}
+		rw.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	for _, fastPath := range []bool{true, false} {
+		// 超时的请求按阶段分类，之后同一个虚拟用户的请求不受影响
+		steps := []struct {
+			query string
+			ok    bool
+			class ErrorClass
+		}{
+			{query: "", ok: true},
+			{query: "?delay=header", class: ErrorTimeoutTTFB},
+			{query: "", ok: true},
+			{query: "?delay=body", class: ErrorTimeoutBody},
+			{query: "", ok: true},
+			{query: "", ok: true},
+		}
+		w := newTestWorker(t, srv.URL, 50*time.Millisecond, fastPath)
+		s := w.newSession()
+		for i, step := range steps {
+			w.picker = newEndpointPicker([]Endpoint{{Name: "default", Weight: 1, Method: w.method, URL: srv.URL + step.query, Headers: w.headers, Generator: w.generator}}, w.stats)
+			if fastPath {
+				prepareTemplates(w.GetEndpointStats())
+			}
+			before := w.stats.ErrorCount(step.class)
+			if got := w.send(w.picker.pick(), time.Now(), s); got != step.ok {
+				t.Fatalf("fastPath=%v step %d %q: send() = %v, want %v", fastPath, i, step.query, got, step.ok)
+			}
+			if !step.ok && w.stats.ErrorCount(step.class) != before+1 {
+				t.Errorf("fastPath=%v step %d %q: error not classified as %v", fastPath, i, step.query, step.class)
+			}
+		}
+		s.close()
+		if w.stats.TotalRequests != 4 || w.stats.FailedRequests != 2 {
+			t.Errorf("fastPath=%v: TotalRequests=%d FailedRequests=%d, want 4, 2", fastPath, w.stats.TotalRequests, w.stats.FailedRequests)
+		}
+	}
+}
+
+func TestSendAborted(t *testing.T) {
+	// 压测被中断后取消的请求不计入统计，之后不会再有请求复用被取消的 context
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer srv.Close()
+	w := newTestWorker(t, srv.URL, time.Second, true)
+	ctx, abort := context.WithCancel(context.Background())
+	w.reqCtx = ctx
+	s := w.newSession()
+	defer s.close()
+	time.AfterFunc(20*time.Millisecond, abort)
+	if w.send(w.picker.pick(), time.Now(), s) {
+		t.Fatal("send() succeeded after abort")
+	}
+	if w.stats.FailedRequests != 0 {
+		t.Errorf("FailedRequests = %d, want 0 for aborted requests", w.stats.FailedRequests)
+	}
+}
+
+func TestSessionTraceReuse(t *testing.T) {
+	s := &session{}
+	first, second := NewRequestStats(), NewRequestStats()
+
+	// 请求取得的是新建的连接，Transport 可能为它发起了拨号，requestTrace 不能复用
+	ctx, cancel, dialed := s.beginRequest(context.Background(), first, time.Second)
+	stale := httptrace.ContextClientTrace(ctx)
+	stale.GotConn(httptrace.GotConnInfo{})
+	stale.WroteRequest(httptrace.WroteRequestInfo{})
+	s.endRequest(cancel, dialed, true)
+	if ctx.Err() == nil {
+		t.Error("request context not canceled by endRequest")
+	}
+	if s.trace != nil {
+		t.Fatal("requestTrace of a request that got a new connection kept for reuse")
+	}
+
+	// 下一个请求使用新的 requestTrace，上一个请求的拨号之后才触发的回调不影响它
+	ctx, cancel, trace := s.beginRequest(context.Background(), second, time.Second)
+	if trace == dialed {
+		t.Fatal("requestTrace reused after a request that got a new connection")
+	}
+	stale.ConnectStart("tcp", "127.0.0.1:80")
+	stale.ConnectDone("tcp", "127.0.0.1:80", nil)
+	stale.TLSHandshakeDone(tls.ConnectionState{}, nil)
+	if trace.connectStart != 0 || trace.connectDone != 0 {
+		t.Error("stale connect callbacks recorded to the next request")
+	}
+	if full, resumed := second.TLSHandshakes(); full+resumed != 0 {
+		t.Errorf("stale TLS handshake counted for the next request: full %d, resumed %d", full, resumed)
+	}
+	if full, _ := first.TLSHandshakes(); full != 1 {
+		t.Errorf("TLS handshake of the dial counted %d times for the request that started it, want 1", full)
+	}
+
+	// 直接取得空闲连接并发完请求的 requestTrace 可以复用
+	current := httptrace.ContextClientTrace(ctx)
+	current.GotConn(httptrace.GotConnInfo{Reused: true, WasIdle: true})
+	current.WroteRequest(httptrace.WroteRequestInfo{})
+	s.endRequest(cancel, trace, true)
+	if s.trace != trace {
+		t.Fatal("requestTrace of a request that got an idle connection not kept for reuse")
+	}
+	_, cancel, reused := s.beginRequest(context.Background(), first, time.Second)
+	if reused != trace || reused.gotConn != 0 || reused.stats.Load() != first {
+		t.Error("kept requestTrace not reset for the next request")
+	}
+
+	// 请求失败或使用HTTP/2时不复用
+	s.endRequest(cancel, reused, false)
+	if s.trace != nil {
+		t.Error("requestTrace kept although the request was not reusable")
+	}
+}
+
+func TestSendReusesTrace(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		rw.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	w := newTestWorker(t, srv.URL, time.Second, true)
+	s := w.newSession()
+	defer s.close()
+	ep := w.picker.pick()
+	if !w.send(ep, time.Now(), s) {
+		t.Fatal("first request failed")
+	}
+	if s.trace != nil {
+		t.Error("requestTrace of the request that dialed the connection kept for reuse")
+	}
+	for i := 0; i < 3; i++ {
+		if !w.send(ep, time.Now(), s) {
+			t.Fatal("request failed")
+		}
+		if s.trace == nil {
+			t.Fatal("requestTrace not kept after a request on an idle keep-alive connection")
+		}
+	}
+}
+
+func TestSendFastPathAllocs(t *testing.T) {
+	// 快速路径使用预先构造的请求并尽量复用 httptrace 回调，分配次数应明显少于逐个生成请求
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		rw.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	allocs := func(fastPath bool) float64 {
+		w := newTestWorker(t, srv.URL, time.Second, fastPath)
+		s := w.newSession()
+		defer s.close()
+		ep := w.picker.pick()
+		return testing.AllocsPerRun(200, func() { w.send(ep, time.Now(), s) })
+	}
+	fast, slow := allocs(true), allocs(false)
+	t.Logf("allocs per request (client and server): fast path %.0f, without %.0f", fast, slow)
+	if fast > slow-20 {
+		t.Errorf("fast path allocates %.0f per request, want at least 20 fewer than %.0f", fast, slow)
+	}
+}
+
+func TestSendRedirect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(rw, r, "/new", http.StatusFound)
+			return
+		}
+		rw.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		status string
+		want   int // 记录到的状态码
+	}{
+		{status: "200", want: http.StatusOK},         // 默认跟随重定向，检查最终响应
+		{status: "200-299,304", want: http.StatusOK}, // 304 不是会被跟随的重定向
+		{status: "302", want: http.StatusFound},
+		{status: "200-399", want: http.StatusFound},
+	}
+	for _, tt := range tests {
+		for _, fastPath := range []bool{true, false} {
+			status, err := ParseStatusSet(tt.status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := newTestWorker(t, srv.URL+"/old", time.Second, fastPath)
+			w.SetSuccessStatus(status)
+			w.client = w.newClient(w.createRoundTripper())
+			s := w.newSession()
+			if !w.send(w.picker.pick(), time.Now(), s) {
+				t.Errorf("success status %s, fastPath=%v: send() failed", tt.status, fastPath)
+			}
+			s.close()
+			if got := w.stats.StatusCounts(); got[tt.want] != 1 || len(got) != 1 {
+				t.Errorf("success status %s, fastPath=%v: status counts %v, want one %d", tt.status, fastPath, got, tt.want)
+			}
+		}
+	}
+}
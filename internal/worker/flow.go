@@ -0,0 +1,118 @@
+package worker
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Extractor 从成功的响应中提取一个变量，供同一虚拟用户之后的请求在模板中以 ${变量名} 引用。支持以下写法：
+//
+//	json:path          响应体按JSON解析后 path 处的值，path 的写法与断言相同
+//	regex:pattern      响应体中正则表达式的第一个匹配，有分组时取第一个分组
+//	header:Name        响应头 Name 的值
+//	cookie:Name        响应通过 Set-Cookie 设置的 Cookie Name 的值
+type Extractor struct {
+	Var     string
+	Expr    string
+	extract func(resp *http.Response, body []byte) (string, error)
+}
+
+// ParseExtractor 解析一个提取规则，name 为变量名
+func ParseExtractor(name, expr string) (*Extractor, error) {
+	kind, arg, ok := strings.Cut(expr, ":")
+	if !ok || arg == "" {
+		return nil, fmt.Errorf("无效的提取规则 %q，格式应为 类型:参数", expr)
+	}
+
+	e := &Extractor{Var: name, Expr: expr}
+	switch kind {
+	case "json":
+		keys, err := parseJSONPath(arg)
+		if err != nil {
+			return nil, fmt.Errorf("提取规则 %q 的路径无效: %v", expr, err)
+		}
+		e.extract = func(resp *http.Response, body []byte) (string, error) {
+			return lookupJSON(body, keys)
+		}
+	case "regex":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("提取规则 %q 的正则表达式无效: %v", expr, err)
+		}
+		e.extract = func(resp *http.Response, body []byte) (string, error) {
+			m := re.FindSubmatch(body)
+			if m == nil {
+				return "", fmt.Errorf("响应体不匹配 %s", arg)
+			}
+			if len(m) > 1 {
+				return string(m[1]), nil
+			}
+			return string(m[0]), nil
+		}
+	case "header":
+		name := http.CanonicalHeaderKey(strings.TrimSpace(arg))
+		e.extract = func(resp *http.Response, body []byte) (string, error) {
+			values, ok := resp.Header[name]
+			if !ok || len(values) == 0 {
+				return "", fmt.Errorf("响应头 %s 不存在", name)
+			}
+			return values[0], nil
+		}
+	case "cookie":
+		e.extract = func(resp *http.Response, body []byte) (string, error) {
+			for _, c := range resp.Cookies() {
+				if c.Name == arg {
+					return c.Value, nil
+				}
+			}
+			return "", fmt.Errorf("响应没有设置 Cookie %s", arg)
+		}
+	default:
+		return nil, fmt.Errorf("不支持的提取类型 %q", kind)
+	}
+	return e, nil
+}
+
+// Extract 从响应中提取变量的值
+func (e *Extractor) Extract(resp *http.Response, body []byte) (string, error) {
+	return e.extract(resp, body)
+}
+
+// ExtractVars 返回所有接口或步骤提取的变量名
+func ExtractVars(endpoints []Endpoint) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, ep := range endpoints {
+		for _, e := range ep.Extract {
+			if !seen[e.Var] {
+				seen[e.Var] = true
+				names = append(names, e.Var)
+			}
+		}
+	}
+	return names
+}
+
+// newSteps 创建多步骤流程中各步骤的统计信息，每个步骤单独统计，并同时累加到整体的统计信息中
+func newSteps(flow []Endpoint, total *RequestStats) []*EndpointStats {
+	vars := ExtractVars(flow)
+	steps := make([]*EndpointStats, len(flow))
+	for i, ep := range flow {
+		steps[i] = newEndpointStats(ep, total.newChild(), vars)
+	}
+	return steps
+}
+
+// runFlow 依次发送流程中的所有步骤，某一步失败时结束本次迭代。
+// 第一步从计划发送时间开始计算响应时间，之后的步骤紧接着上一步发送。
+func (w *Worker) runFlow(intended time.Time, s *session) {
+	for _, step := range w.steps {
+		if !w.send(step, intended, s) {
+			return
+		}
+		intended = time.Now()
+	}
+}
@@ -0,0 +1,80 @@
+package worker
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestParseExtractor(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"json",
+		"json:",
+		"xpath://id",
+		"regex:(",
+		"json:a..b",
+		"json:items[x]",
+	} {
+		if _, err := ParseExtractor("v", expr); err == nil {
+			t.Errorf("ParseExtractor(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestExtractorExtract(t *testing.T) {
+	resp := &http.Response{Header: http.Header{
+		"X-Request-Id": {"r-1", "r-2"},
+		"Set-Cookie":   {"sid=abc; Path=/; HttpOnly", "theme=dark"},
+	}}
+	body := []byte(`{"data":{"token":"t-42","ids":[3,4]}} order=9001`)
+	tests := []struct {
+		expr    string
+		want    string
+		wantErr bool
+	}{
+		{expr: "json:data.token", want: "t-42"},
+		{expr: "json:data.ids[1]", want: "4"},
+		{expr: "regex:order=(\\d+)", want: "9001"},
+		{expr: "regex:order=\\d+", want: "order=9001"},
+		{expr: "header:x-request-id", want: "r-1"},
+		{expr: "cookie:sid", want: "abc"},
+		{expr: "cookie:theme", want: "dark"},
+		{expr: "json:data.user", wantErr: true},
+		{expr: "regex:missing=(\\d+)", wantErr: true},
+		{expr: "header:X-Trace", wantErr: true},
+		{expr: "cookie:SID", wantErr: true},
+	}
+	for _, tt := range tests {
+		e, err := ParseExtractor("v", tt.expr)
+		if err != nil {
+			t.Errorf("ParseExtractor(%q) error: %v", tt.expr, err)
+			continue
+		}
+		got, err := e.Extract(resp, body)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q Extract() error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("%q Extract() = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestExtractVars(t *testing.T) {
+	token, _ := ParseExtractor("token", "json:token")
+	id, _ := ParseExtractor("id", "json:id")
+	again, _ := ParseExtractor("token", "header:X-Token")
+	endpoints := []Endpoint{
+		{Extract: []*Extractor{token, id}},
+		{},
+		{Extract: []*Extractor{again}},
+	}
+	if got, want := ExtractVars(endpoints), []string{"token", "id"}; !slices.Equal(got, want) {
+		t.Errorf("ExtractVars() = %q, want %q", got, want)
+	}
+	if got := ExtractVars(nil); got != nil {
+		t.Errorf("ExtractVars(nil) = %q, want nil", got)
+	}
+}
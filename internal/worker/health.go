@@ -0,0 +1,214 @@
+package worker
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// lateTickThreshold 调度器的一批请求比计划时间晚这么久以上才交给发送协程时，计为延迟的批次
+const lateTickThreshold = 10 * time.Millisecond
+
+// 判断客户端饱和的界限
+const (
+	maxLateTickRatio = 0.01  // 延迟的批次占全部批次的比例
+	minLateTicks     = 5     // 延迟的批次至少有这么多才判断比例，避免低QPS、短时间的压测中偶尔一次延迟就被判为饱和
+	maxDroppedRatio  = 0.001 // 在客户端丢弃的请求占计划发送请求的比例
+	maxQueuedRatio   = 0.05  // 需要排队的请求占计划发送请求的比例
+	maxCPURatio      = 0.9   // 进程的CPU使用率占全部可用CPU的比例
+)
+
+// ClientHealth 压测客户端自身的状态。客户端跟不上目标负载时，服务端实际承受的负载低于目标，
+// 压测结果反映的是客户端而不是服务端的能力。
+type ClientHealth struct {
+	// QPS模式或分阶段模式下为true。只有这时才有目标负载，客户端跟不上时结果无效；
+	// 并发模式下客户端的限制只会降低每秒请求数，作为提示
+	RateDriven bool
+	// QPS模式下调度器每批请求交给发送协程的时间比计划时间晚的时长
+	LagHistogram *Histogram
+	Ticks        int64
+	LateTicks    int64
+	// 在客户端丢弃的请求数，包括发送通道已满时丢弃的请求和压测结束时仍在通道中排队、被放弃的请求（Abandoned），
+	// 这些请求没有发出，不计为失败请求
+	Dropped   int64
+	Abandoned int64
+	// 调度器计划发送的请求数，用于计算丢弃和排队的比例
+	Scheduled int64
+	// QPS模式下发送协程的数量、同时在发送请求的最大协程数，以及交给发送协程时所有发送协程都在忙的请求数
+	PoolSize int64
+	PeakBusy int64
+	Queued   int64
+	busy     int64
+	// 压测期间进程使用的CPU时间（用户态和内核态）和可用的CPU数，CPUMeasured 为false时表示当前平台不支持统计
+	CPUTime     time.Duration
+	CPUMeasured bool
+	CPUs        int
+	Wall        time.Duration
+	cpuStart    time.Duration
+}
+
+// newClientHealth 创建客户端状态的统计
+func newClientHealth() *ClientHealth {
+	return &ClientHealth{LagHistogram: NewHistogram(), CPUs: runtime.GOMAXPROCS(0)}
+}
+
+// start 压测开始时记录进程已使用的CPU时间
+func (h *ClientHealth) start() {
+	h.cpuStart, h.CPUMeasured = ProcessCPUTime()
+}
+
+// finish 压测结束时计算压测期间使用的CPU时间，wall 为压测的实际时长，scheduled 为调度器计划发送的请求数
+func (h *ClientHealth) finish(wall time.Duration, scheduled int64) {
+	h.Wall = wall
+	h.Scheduled = scheduled
+	if end, ok := ProcessCPUTime(); ok && h.CPUMeasured {
+		h.CPUTime = end - h.cpuStart
+	}
+}
+
+// recordTick 记录调度器的一批请求交给发送协程时比计划时间晚的时长
+func (h *ClientHealth) recordTick(intended time.Time) {
+	lag := time.Since(intended)
+	h.LagHistogram.Record(lag)
+	atomic.AddInt64(&h.Ticks, 1)
+	if lag > lateTickThreshold {
+		atomic.AddInt64(&h.LateTicks, 1)
+	}
+}
+
+// recordDropped 记录一个在客户端丢弃的请求，返回是否是第一个被丢弃的请求
+func (h *ClientHealth) recordDropped() bool {
+	return atomic.AddInt64(&h.Dropped, 1) == 1
+}
+
+// recordAbandoned 记录压测结束时仍在发送通道中排队、没有发出的请求
+func (h *ClientHealth) recordAbandoned(n int) {
+	atomic.AddInt64(&h.Abandoned, int64(n))
+	atomic.AddInt64(&h.Dropped, int64(n))
+}
+
+// recordQueued 调度器交给发送协程一个请求时，所有发送协程都在忙则记为排队
+func (h *ClientHealth) recordQueued() {
+	if atomic.LoadInt64(&h.busy) >= h.PoolSize {
+		atomic.AddInt64(&h.Queued, 1)
+	}
+}
+
+// senderBusy 发送协程开始发送请求
+func (h *ClientHealth) senderBusy() {
+	updateMax(&h.PeakBusy, atomic.AddInt64(&h.busy, 1))
+}
+
+// senderIdle 发送协程发送完请求
+func (h *ClientHealth) senderIdle() {
+	atomic.AddInt64(&h.busy, -1)
+}
+
+// CPUUsage 返回压测期间进程的CPU使用率占全部可用CPU的比例，不支持统计时返回false
+func (h *ClientHealth) CPUUsage() (float64, bool) {
+	if !h.CPUMeasured || h.Wall <= 0 {
+		return 0, false
+	}
+	return h.CPUTime.Seconds() / h.Wall.Seconds() / float64(h.CPUs), true
+}
+
+// Problems 返回说明客户端跟不上目标负载、压测结果无效的原因，只在QPS模式和分阶段模式下判断
+func (h *ClientHealth) Problems() []string {
+	if !h.RateDriven {
+		return nil
+	}
+	var problems []string
+	if h.exceeds(h.Dropped, maxDroppedRatio) {
+		problems = append(problems, fmt.Sprintf("客户端丢弃了 %d 个请求（%.1f%%），其中 %d 个在压测结束时仍在排队",
+			h.Dropped, h.ratio(h.Dropped)*100, h.Abandoned))
+	}
+	if h.exceeds(h.Queued, maxQueuedRatio) {
+		problems = append(problems, fmt.Sprintf("发送协程全部被占用，%d 个请求（%.1f%%）在客户端排队，可以增大 --max-workers",
+			h.Queued, h.ratio(h.Queued)*100))
+	}
+	if h.LateTicks >= minLateTicks && float64(h.LateTicks) > float64(h.Ticks)*maxLateTickRatio {
+		problems = append(problems, fmt.Sprintf("调度器有 %.1f%% 的批次比计划时间晚 %v 以上才发出",
+			float64(h.LateTicks)*100/float64(h.Ticks), lateTickThreshold))
+	}
+	if usage, ok := h.CPUUsage(); ok && usage > maxCPURatio {
+		problems = append(problems, fmt.Sprintf("wrkx 使用了 %.0f%% 的CPU（%d个）", usage*100, h.CPUs))
+	}
+	return problems
+}
+
+// Warnings 返回不一定导致结果无效、但说明客户端接近极限的情况
+func (h *ClientHealth) Warnings() []string {
+	var warnings []string
+	if h.Dropped > 0 && !h.exceeds(h.Dropped, maxDroppedRatio) {
+		warnings = append(warnings, fmt.Sprintf("客户端丢弃了 %d 个请求", h.Dropped))
+	}
+	if h.Queued > 0 && !h.exceeds(h.Queued, maxQueuedRatio) {
+		warnings = append(warnings, fmt.Sprintf("发送协程全部被占用，%d 个请求在客户端排队，可以增大 --max-workers", h.Queued))
+	}
+	if usage, ok := h.CPUUsage(); ok && usage > maxCPURatio && !h.RateDriven {
+		warnings = append(warnings, fmt.Sprintf("wrkx 使用了 %.0f%% 的CPU（%d个），每秒请求数可能受客户端限制", usage*100, h.CPUs))
+	}
+	return warnings
+}
+
+// ratio 返回 n 占计划发送请求数的比例
+func (h *ClientHealth) ratio(n int64) float64 {
+	if h.Scheduled <= 0 {
+		return 0
+	}
+	return float64(n) / float64(h.Scheduled)
+}
+
+// exceeds 判断 n 占计划发送请求数的比例是否超过 limit，没有计划发送的请求时只要 n 大于0就算超过
+func (h *ClientHealth) exceeds(n int64, limit float64) bool {
+	if h.Scheduled <= 0 {
+		return n > 0
+	}
+	return h.ratio(n) > limit
+}
+
+// Saturated 判断客户端是否跟不上目标负载
+func (h *ClientHealth) Saturated() bool {
+	return len(h.Problems()) > 0
+}
+
+// print 打印客户端状态
+func (h *ClientHealth) print() {
+	fmt.Printf("\n客户端状态:\n")
+	if h.Ticks > 0 {
+		lag := h.LagHistogram.Snapshot()
+		fmt.Printf("  调度延迟         p50 %v, p99 %v, 最大 %v\n",
+			lag.Quantile(0.50).Round(time.Microsecond), lag.Quantile(0.99).Round(time.Microsecond),
+			time.Duration(lag.Max).Round(time.Microsecond))
+		fmt.Printf("  延迟的批次       %d / %d（晚于 %v）\n", h.LateTicks, h.Ticks, lateTickThreshold)
+		fmt.Printf("  客户端丢弃       %d（其中压测结束时仍在排队 %d）\n", h.Dropped, h.Abandoned)
+	}
+	if h.PoolSize > 0 {
+		fmt.Printf("  发送协程         最多同时使用 %d / %d，排队的请求 %d\n", h.PeakBusy, h.PoolSize, h.Queued)
+	}
+	if usage, ok := h.CPUUsage(); ok {
+		fmt.Printf("  CPU              %.1f%%（CPU时间 %v，可用CPU %d个）\n", usage*100, h.CPUTime.Round(time.Millisecond), h.CPUs)
+	} else {
+		fmt.Printf("  CPU              当前平台不支持统计\n")
+	}
+}
+
+// PrintWarning 客户端跟不上目标负载时醒目地打印警告和原因，接近极限时打印提示
+func (h *ClientHealth) PrintWarning() {
+	for _, warning := range h.Warnings() {
+		fmt.Printf("\n提示：%s\n", warning)
+	}
+	problems := h.Problems()
+	if len(problems) == 0 {
+		return
+	}
+	line := "!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!"
+	fmt.Printf("\n%s\n", line)
+	fmt.Printf("!! 警告：压测客户端已饱和，没有产生目标负载，以上结果无效\n")
+	for _, p := range problems {
+		fmt.Printf("!!   - %s\n", p)
+	}
+	fmt.Printf("!! 请减少目标负载、增大 --max-workers、增加 wrkx 可用的CPU，或者在多台机器上同时压测\n")
+	fmt.Printf("%s\n", line)
+}
@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientHealthProblems(t *testing.T) {
+	tests := []struct {
+		name         string
+		health       ClientHealth
+		wantProblems int
+		wantWarnings int
+	}{
+		{
+			name:   "healthy",
+			health: ClientHealth{Scheduled: 10000, Ticks: 1000, Dropped: 1, Queued: 100},
+			// 丢弃和排队都没有超过比例，只提示
+			wantWarnings: 2,
+		},
+		{
+			name:         "dropped",
+			health:       ClientHealth{Scheduled: 10000, Dropped: 11},
+			wantProblems: 1,
+		},
+		{
+			name:         "queued",
+			health:       ClientHealth{Scheduled: 10000, Queued: 501},
+			wantProblems: 1,
+		},
+		{
+			name:         "late ticks",
+			health:       ClientHealth{Scheduled: 10000, Ticks: 100, LateTicks: 5},
+			wantProblems: 1,
+		},
+		{
+			name:   "few late ticks",
+			health: ClientHealth{Scheduled: 10000, Ticks: 100, LateTicks: 4},
+		},
+		{
+			name:         "cpu",
+			health:       ClientHealth{Scheduled: 10000, CPUMeasured: true, CPUTime: 1900 * time.Millisecond, Wall: time.Second, CPUs: 2},
+			wantProblems: 1,
+		},
+		{
+			name:   "cpu below limit",
+			health: ClientHealth{Scheduled: 10000, CPUMeasured: true, CPUTime: 1700 * time.Millisecond, Wall: time.Second, CPUs: 2},
+		},
+		{
+			name:   "cpu not measured",
+			health: ClientHealth{Scheduled: 10000, CPUTime: 1900 * time.Millisecond, Wall: time.Second, CPUs: 2},
+		},
+	}
+	for _, tt := range tests {
+		h := tt.health
+		h.RateDriven = true
+		if got := h.Problems(); len(got) != tt.wantProblems {
+			t.Errorf("%s: Problems() = %q, want %d problems", tt.name, got, tt.wantProblems)
+		}
+		if got := h.Warnings(); len(got) != tt.wantWarnings {
+			t.Errorf("%s: Warnings() = %q, want %d warnings", tt.name, got, tt.wantWarnings)
+		}
+		if got := h.Saturated(); got != (tt.wantProblems > 0) {
+			t.Errorf("%s: Saturated() = %v, want %v", tt.name, got, tt.wantProblems > 0)
+		}
+	}
+}
+
+func TestClientHealthConcurrencyMode(t *testing.T) {
+	// 并发模式下没有目标负载，CPU用满只是提示，不判断为饱和
+	h := ClientHealth{CPUMeasured: true, CPUTime: 1900 * time.Millisecond, Wall: time.Second, CPUs: 2}
+	if got := h.Problems(); len(got) != 0 {
+		t.Errorf("Problems() = %q, want none", got)
+	}
+	if h.Saturated() {
+		t.Error("Saturated() = true in concurrency mode")
+	}
+	if got := h.Warnings(); len(got) != 1 {
+		t.Errorf("Warnings() = %q, want 1 warning", got)
+	}
+
+	h.RateDriven = true
+	if got := h.Warnings(); len(got) != 0 {
+		t.Errorf("Warnings() = %q in QPS mode, want the CPU usage reported as a problem instead", got)
+	}
+	if !h.Saturated() {
+		t.Error("Saturated() = false in QPS mode")
+	}
+}
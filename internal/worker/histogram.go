@@ -0,0 +1,326 @@
+package worker
+
+import (
+	"math"
+	"math/bits"
+	"math/rand/v2"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// 直方图采用HDR（High Dynamic Range）的对数-线性分桶方式：
+// 小于 histSubBucketCount 纳秒的值按1ns精度记录，之后每翻一倍划分 histSubBucketHalf 个桶，
+// 相对误差不超过 1/histSubBucketHalf（约0.8%），可覆盖1ns到数小时的延迟范围。
+const (
+	histSubBucketBits  = 8
+	histSubBucketCount = 1 << histSubBucketBits
+	histSubBucketHalf  = histSubBucketCount / 2
+	histMaxShift       = 36 // 最大可记录约 2^44ns（约4.9小时），超出部分记入最后一个桶
+	histBucketCount    = histSubBucketCount + histMaxShift*histSubBucketHalf
+	histMaxShards      = 16
+)
+
+// histBucketIndex 计算值所在的桶下标
+func histBucketIndex(v int64) int {
+	if v < 0 {
+		v = 0
+	}
+	if v < histSubBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - histSubBucketBits
+	if shift > histMaxShift {
+		return histBucketCount - 1
+	}
+	return histSubBucketCount + (shift-1)*histSubBucketHalf + int(v>>shift) - histSubBucketHalf
+}
+
+// histBucketBounds 返回桶所覆盖的值区间 [low, high]
+func histBucketBounds(index int) (low, high int64) {
+	if index < histSubBucketCount {
+		return int64(index), int64(index)
+	}
+	k := index - histSubBucketCount
+	shift := k/histSubBucketHalf + 1
+	m := int64(k%histSubBucketHalf + histSubBucketHalf)
+	return m << shift, (m+1)<<shift - 1
+}
+
+// histShard 是直方图的一个分片，多个分片用于降低高QPS下的原子操作竞争。
+// 每个分片约38KB，在第一次写入时才分配，没有请求的接口、流程步骤和连接复用时不会发生的阶段不占用内存
+type histShard struct {
+	counts [histBucketCount]int64
+	sum    int64
+}
+
+// Histogram 是一个无锁、分片的HDR延迟直方图，记录整个压测期间的所有请求。
+// 直方图只会累加，需要某个时间区间的数据时，对前后两次快照做差即可。
+type Histogram struct {
+	shards []atomic.Pointer[histShard]
+	min    int64
+	max    int64
+}
+
+// NewHistogram 创建一个新的直方图，分片数与GOMAXPROCS一致
+func NewHistogram() *Histogram {
+	n := runtime.GOMAXPROCS(0)
+	if n > histMaxShards {
+		n = histMaxShards
+	}
+	return &Histogram{
+		shards: make([]atomic.Pointer[histShard], n),
+		min:    int64(time.Hour),
+		max:    0,
+	}
+}
+
+// Record 记录一个延迟值
+func (h *Histogram) Record(d time.Duration) {
+	v := int64(d)
+	if v < 0 {
+		v = 0
+	}
+	shard := h.shard(rand.IntN(len(h.shards)))
+	atomic.AddInt64(&shard.counts[histBucketIndex(v)], 1)
+	atomic.AddInt64(&shard.sum, v)
+	updateMin(&h.min, v)
+	updateMax(&h.max, v)
+}
+
+// shard 返回第 i 个分片，第一次使用时分配
+func (h *Histogram) shard(i int) *histShard {
+	if shard := h.shards[i].Load(); shard != nil {
+		return shard
+	}
+	h.shards[i].CompareAndSwap(nil, &histShard{})
+	return h.shards[i].Load()
+}
+
+// Snapshot 合并所有分片，返回当前累计数据的快照。压测过程中各计数不是同时读取的，
+// 总数由各桶的计数相加得到，保证与桶一致；最小/最大值还没来得及更新时按桶的边界修正。
+func (h *Histogram) Snapshot() *HistogramSnapshot {
+	s := &HistogramSnapshot{Counts: make([]int64, histBucketCount)}
+	for j := range h.shards {
+		shard := h.shards[j].Load()
+		if shard == nil {
+			continue
+		}
+		for i := range shard.counts {
+			if c := atomic.LoadInt64(&shard.counts[i]); c != 0 {
+				s.Counts[i] += c
+			}
+		}
+		s.Sum += atomic.LoadInt64(&shard.sum)
+	}
+	first, last := -1, -1
+	for i, c := range s.Counts {
+		if c == 0 {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+		s.Count += c
+	}
+	if s.Count > 0 {
+		s.Min = atomic.LoadInt64(&h.min)
+		s.Max = atomic.LoadInt64(&h.max)
+		if low, high := histBucketBounds(first); s.Min > high {
+			s.Min = low
+		}
+		if low, _ := histBucketBounds(last); s.Max < low {
+			s.Max = low
+		}
+	}
+	return s
+}
+
+// HistogramSnapshot 是直方图在某一时刻的只读快照
+type HistogramSnapshot struct {
+	Counts []int64
+	Count  int64
+	Sum    int64
+	Min    int64
+	Max    int64
+}
+
+// Sub 计算两次快照之间的增量，用于得到每秒的延迟分布。
+// 区间内的最小/最大值根据桶的边界估算。
+func (s *HistogramSnapshot) Sub(prev *HistogramSnapshot) *HistogramSnapshot {
+	if prev == nil {
+		return s
+	}
+	d := &HistogramSnapshot{
+		Counts: make([]int64, len(s.Counts)),
+		Count:  s.Count - prev.Count,
+		Sum:    s.Sum - prev.Sum,
+	}
+	for i := range s.Counts {
+		d.Counts[i] = s.Counts[i] - prev.Counts[i]
+	}
+	d.estimateMinMax(s.Max)
+	return d
+}
+
+// Merge 将另一个快照的数据合并到当前快照
+func (s *HistogramSnapshot) Merge(other *HistogramSnapshot) {
+	if other == nil || other.Count == 0 {
+		return
+	}
+	if s.Count == 0 || other.Min < s.Min {
+		s.Min = other.Min
+	}
+	if other.Max > s.Max {
+		s.Max = other.Max
+	}
+	for i := range other.Counts {
+		s.Counts[i] += other.Counts[i]
+	}
+	s.Count += other.Count
+	s.Sum += other.Sum
+}
+
+// estimateMinMax 根据非空桶的边界估算最小/最大值，最大值不超过 limit
+func (s *HistogramSnapshot) estimateMinMax(limit int64) {
+	if s.Count <= 0 {
+		return
+	}
+	for i, c := range s.Counts {
+		if c > 0 {
+			s.Min, _ = histBucketBounds(i)
+			break
+		}
+	}
+	for i := len(s.Counts) - 1; i >= 0; i-- {
+		if s.Counts[i] > 0 {
+			_, s.Max = histBucketBounds(i)
+			break
+		}
+	}
+	if s.Max > limit {
+		s.Max = limit
+	}
+}
+
+// Mean 返回平均值
+func (s *HistogramSnapshot) Mean() time.Duration {
+	if s.Count <= 0 {
+		return 0
+	}
+	return time.Duration(s.Sum / s.Count)
+}
+
+// Quantile 返回分位数q（0~1）对应的延迟，结果为所在桶的上界并限制在[Min, Max]之内
+func (s *HistogramSnapshot) Quantile(q float64) time.Duration {
+	if s.Count <= 0 {
+		return 0
+	}
+	rank := int64(q * float64(s.Count))
+	if float64(rank) < q*float64(s.Count) {
+		rank++
+	}
+	if rank < 1 {
+		rank = 1
+	}
+
+	var cumulative int64
+	for i, c := range s.Counts {
+		cumulative += c
+		if cumulative >= rank {
+			_, high := histBucketBounds(i)
+			if high > s.Max {
+				high = s.Max
+			}
+			if high < s.Min {
+				high = s.Min
+			}
+			return time.Duration(high)
+		}
+	}
+	return time.Duration(s.Max)
+}
+
+// CountAtOrBelow 返回不超过d的请求数，d所在的桶整体计入，误差与桶的精度一致
+func (s *HistogramSnapshot) CountAtOrBelow(d time.Duration) int64 {
+	last := histBucketIndex(int64(d))
+	var n int64
+	for i := 0; i <= last && i < len(s.Counts); i++ {
+		n += s.Counts[i]
+	}
+	return n
+}
+
+// StdDev 根据各桶的中点估算标准差
+func (s *HistogramSnapshot) StdDev() time.Duration {
+	if s.Count <= 0 {
+		return 0
+	}
+	mean := float64(s.Sum) / float64(s.Count)
+	var variance float64
+	for i, c := range s.Counts {
+		if c == 0 {
+			continue
+		}
+		low, high := histBucketBounds(i)
+		d := float64(low+high)/2 - mean
+		variance += d * d * float64(c)
+	}
+	return time.Duration(math.Sqrt(variance / float64(s.Count)))
+}
+
+// HistogramBin 是延迟分布图中的一个区间
+type HistogramBin struct {
+	Low   time.Duration
+	High  time.Duration
+	Count int64
+}
+
+// Distribution 将[Min, p99.9]等分为n个区间统计请求数，超过p99.9的长尾单独作为最后一个区间
+func (s *HistogramSnapshot) Distribution(n int) []HistogramBin {
+	if s.Count <= 0 || n <= 0 {
+		return nil
+	}
+	low := s.Min
+	high := int64(s.Quantile(0.999))
+	if high <= low {
+		high = low + 1
+	}
+	width := (high - low + int64(n) - 1) / int64(n)
+	if width < 1 {
+		width = 1
+	}
+
+	bins := make([]HistogramBin, n)
+	for i := range bins {
+		bins[i].Low = time.Duration(low + int64(i)*width)
+		bins[i].High = time.Duration(low + int64(i+1)*width)
+	}
+	var tail HistogramBin
+	tail.Low = bins[n-1].High
+	tail.High = time.Duration(s.Max)
+
+	for i, c := range s.Counts {
+		if c == 0 {
+			continue
+		}
+		bucketLow, bucketHigh := histBucketBounds(i)
+		v := (bucketLow + bucketHigh) / 2
+		if v < low {
+			v = low
+		}
+		idx := int((v - low) / width)
+		if idx >= n {
+			tail.Count += c
+			continue
+		}
+		bins[idx].Count += c
+	}
+	if tail.Count > 0 && tail.High > tail.Low {
+		bins = append(bins, tail)
+	} else if tail.Count > 0 {
+		bins[n-1].Count += tail.Count
+	}
+	return bins
+}
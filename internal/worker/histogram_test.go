@@ -0,0 +1,303 @@
+package worker
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHistBucketIndexBounds(t *testing.T) {
+	values := []int64{0, 1, 255, 256, 257, 511, 512, 513, 1000, 1023, 1024,
+		int64(time.Microsecond), int64(time.Millisecond), 123456789, int64(time.Second), int64(time.Hour)}
+	for shift := 0; shift < 44; shift++ {
+		v := int64(1) << shift
+		values = append(values, v-1, v, v+1)
+	}
+	for _, v := range values {
+		i := histBucketIndex(v)
+		if i < 0 || i >= histBucketCount {
+			t.Fatalf("histBucketIndex(%d) = %d, out of range", v, i)
+		}
+		low, high := histBucketBounds(i)
+		if v < low || v > high {
+			t.Errorf("value %d in bucket %d with bounds [%d, %d]", v, i, low, high)
+		}
+		// 相对误差不超过 1/histSubBucketHalf
+		if v >= histSubBucketCount {
+			if err := float64(high-low) / float64(low); err > 1.0/histSubBucketHalf {
+				t.Errorf("bucket %d [%d, %d] relative width %.4f too large", i, low, high, err)
+			}
+		} else if low != v || high != v {
+			t.Errorf("value %d below %d not recorded exactly: [%d, %d]", v, histSubBucketCount, low, high)
+		}
+	}
+}
+
+func TestHistBucketsContiguous(t *testing.T) {
+	// 除最后一个记录溢出值的桶外，每个桶的下界都紧接着上一个桶的上界，且下标与边界可以互相转换
+	_, prevHigh := histBucketBounds(0)
+	for i := 1; i < histBucketCount; i++ {
+		low, high := histBucketBounds(i)
+		if low != prevHigh+1 {
+			t.Fatalf("bucket %d starts at %d, previous bucket ends at %d", i, low, prevHigh)
+		}
+		if high < low {
+			t.Fatalf("bucket %d has bounds [%d, %d]", i, low, high)
+		}
+		if got := histBucketIndex(low); got != i {
+			t.Fatalf("histBucketIndex(%d) = %d, want %d", low, got, i)
+		}
+		if got := histBucketIndex(high); got != i {
+			t.Fatalf("histBucketIndex(%d) = %d, want %d", high, got, i)
+		}
+		prevHigh = high
+	}
+}
+
+func TestHistBucketIndexEdges(t *testing.T) {
+	if got := histBucketIndex(-5); got != 0 {
+		t.Errorf("histBucketIndex(-5) = %d, want 0", got)
+	}
+	if got := histBucketIndex(math.MaxInt64); got != histBucketCount-1 {
+		t.Errorf("histBucketIndex(MaxInt64) = %d, want last bucket %d", got, histBucketCount-1)
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	h := NewHistogram()
+	var values []int64
+	r := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < 100000; i++ {
+		// 对数分布，覆盖微秒到秒
+		v := int64(math.Exp(r.Float64()*math.Log(float64(time.Second)/float64(time.Microsecond))) * float64(time.Microsecond))
+		values = append(values, v)
+		h.Record(time.Duration(v))
+	}
+	slices.Sort(values)
+
+	s := h.Snapshot()
+	if s.Count != int64(len(values)) {
+		t.Fatalf("Count = %d, want %d", s.Count, len(values))
+	}
+	if s.Min != values[0] || s.Max != values[len(values)-1] {
+		t.Errorf("Min, Max = %d, %d, want %d, %d", s.Min, s.Max, values[0], values[len(values)-1])
+	}
+	for _, q := range []float64{0.01, 0.5, 0.75, 0.9, 0.99, 0.999, 0.9999, 1} {
+		exact := values[int(math.Ceil(q*float64(len(values))))-1]
+		got := int64(s.Quantile(q))
+		if got < exact || float64(got-exact)/float64(exact) > 1.0/histSubBucketHalf {
+			t.Errorf("Quantile(%v) = %d, exact %d, want within %.2f%% above", q, got, exact, 100.0/histSubBucketHalf)
+		}
+	}
+	// 分位数取所在桶的上界，q=0 时为最小值所在的桶
+	if _, high := histBucketBounds(histBucketIndex(values[0])); int64(s.Quantile(0)) < values[0] || int64(s.Quantile(0)) > high {
+		t.Errorf("Quantile(0) = %v, want within the bucket of min %v", s.Quantile(0), time.Duration(values[0]))
+	}
+}
+
+func TestHistogramSnapshotStats(t *testing.T) {
+	h := NewHistogram()
+	if s := h.Snapshot(); s.Count != 0 || s.Quantile(0.99) != 0 || s.Mean() != 0 || s.StdDev() != 0 || s.Min != 0 || s.Max != 0 {
+		t.Errorf("empty snapshot = %+v, want zero values", s)
+	}
+
+	for _, v := range []time.Duration{10, 20, 30, 40} {
+		h.Record(v)
+	}
+	h.Record(-time.Second) // 负数按0记录
+	s := h.Snapshot()
+	if s.Count != 5 || s.Sum != 100 || s.Min != 0 || s.Max != 40 {
+		t.Errorf("snapshot Count=%d Sum=%d Min=%d Max=%d, want 5, 100, 0, 40", s.Count, s.Sum, s.Min, s.Max)
+	}
+	if got := s.Mean(); got != 20 {
+		t.Errorf("Mean() = %v, want 20ns", got)
+	}
+	if got := s.StdDev(); got != 14 { // sqrt(200) 取整
+		t.Errorf("StdDev() = %v, want 14ns", got)
+	}
+	if got := s.Quantile(0.5); got != 20 {
+		t.Errorf("Quantile(0.5) = %v, want 20ns", got)
+	}
+	if got := s.CountAtOrBelow(25); got != 3 {
+		t.Errorf("CountAtOrBelow(25) = %d, want 3", got)
+	}
+}
+
+func TestHistogramSnapshotSub(t *testing.T) {
+	h := NewHistogram()
+	for i := 0; i < 100; i++ {
+		h.Record(time.Millisecond)
+	}
+	prev := h.Snapshot()
+	for i := 0; i < 50; i++ {
+		h.Record(10 * time.Millisecond)
+	}
+	d := h.Snapshot().Sub(prev)
+	if d.Count != 50 || d.Sum != int64(500*time.Millisecond) {
+		t.Fatalf("Sub Count=%d Sum=%d, want 50, %d", d.Count, d.Sum, int64(500*time.Millisecond))
+	}
+	low, high := histBucketBounds(histBucketIndex(int64(10 * time.Millisecond)))
+	if d.Min != low || d.Max != int64(10*time.Millisecond) || d.Max > high {
+		t.Errorf("Sub Min=%d Max=%d, want %d, %d", d.Min, d.Max, low, int64(10*time.Millisecond))
+	}
+	if got := d.Quantile(0.5); got != 10*time.Millisecond {
+		t.Errorf("Sub Quantile(0.5) = %v, want 10ms", got)
+	}
+	if got := h.Snapshot().Sub(nil); got.Count != 150 {
+		t.Errorf("Sub(nil).Count = %d, want 150", got.Count)
+	}
+}
+
+func TestHistogramSnapshotMerge(t *testing.T) {
+	a, b := NewHistogram(), NewHistogram()
+	a.Record(5 * time.Millisecond)
+	b.Record(time.Millisecond)
+	b.Record(9 * time.Millisecond)
+
+	s := a.Snapshot()
+	s.Merge(b.Snapshot())
+	s.Merge(nil)
+	s.Merge(NewHistogram().Snapshot())
+	if s.Count != 3 || s.Min != int64(time.Millisecond) || s.Max != int64(9*time.Millisecond) {
+		t.Errorf("merged Count=%d Min=%d Max=%d, want 3, 1ms, 9ms", s.Count, s.Min, s.Max)
+	}
+
+	empty := NewHistogram().Snapshot()
+	empty.Merge(a.Snapshot())
+	if empty.Count != 1 || empty.Min != int64(5*time.Millisecond) {
+		t.Errorf("merge into empty Count=%d Min=%d, want 1, 5ms", empty.Count, empty.Min)
+	}
+}
+
+func TestHistogramLazyShards(t *testing.T) {
+	// 分片在第一次写入时才分配，没有记录的直方图不占用分片的内存
+	allocated := func(h *Histogram) int {
+		n := 0
+		for i := range h.shards {
+			if h.shards[i].Load() != nil {
+				n++
+			}
+		}
+		return n
+	}
+	h := NewHistogram()
+	if n := allocated(h); n != 0 {
+		t.Fatalf("new histogram has %d shards allocated, want 0", n)
+	}
+	if s := h.Snapshot(); s.Count != 0 || len(s.Counts) != histBucketCount {
+		t.Errorf("empty snapshot Count=%d len(Counts)=%d, want 0, %d", s.Count, len(s.Counts), histBucketCount)
+	}
+	h.Record(time.Millisecond)
+	if n := allocated(h); n != 1 {
+		t.Errorf("%d shards allocated after one record, want 1", n)
+	}
+
+	// 多个协程同时第一次写入同一个分片时只保留一个分片，记录不会丢失
+	h = NewHistogram()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				h.Record(time.Microsecond)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := h.Snapshot().Count; got != 8000 {
+		t.Errorf("Count = %d, want 8000", got)
+	}
+
+	rs := NewRequestStats()
+	for _, hist := range append([]*Histogram{rs.LatencyHistogram, rs.ResponseTimeHistogram}, rs.PhaseHistograms[:]...) {
+		if n := allocated(hist); n != 0 {
+			t.Errorf("NewRequestStats allocated %d histogram shards before any request, want 0", n)
+		}
+	}
+}
+
+func TestHistogramSnapshotConsistent(t *testing.T) {
+	// 记录的同时做快照，总数必须等于各桶之和，累计计数随上界单调不减，分位数在 [Min, Max] 之内
+	h := NewHistogram()
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(seed uint64) {
+			defer wg.Done()
+			r := rand.New(rand.NewPCG(seed, seed))
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					h.Record(time.Duration(r.Int64N(int64(time.Second))))
+				}
+			}
+		}(uint64(i))
+	}
+	for i := 0; i < 200; i++ {
+		s := h.Snapshot()
+		var sum int64
+		for _, c := range s.Counts {
+			sum += c
+		}
+		if sum != s.Count {
+			t.Fatalf("snapshot Count %d != sum of buckets %d", s.Count, sum)
+		}
+		if s.Count == 0 {
+			continue
+		}
+		if s.Min > s.Max {
+			t.Fatalf("snapshot Min %d > Max %d", s.Min, s.Max)
+		}
+		if p := int64(s.Quantile(0.99)); p < s.Min || p > s.Max {
+			t.Fatalf("Quantile(0.99) = %d outside [%d, %d]", p, s.Min, s.Max)
+		}
+		var prev int64
+		for _, le := range []time.Duration{time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond, time.Second, time.Hour} {
+			n := s.CountAtOrBelow(le)
+			if n < prev || n > s.Count {
+				t.Fatalf("CountAtOrBelow(%v) = %d, previous %d, Count %d", le, n, prev, s.Count)
+			}
+			prev = n
+		}
+		if prev != s.Count {
+			t.Fatalf("CountAtOrBelow(1h) = %d, want Count %d", prev, s.Count)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestHistogramDistribution(t *testing.T) {
+	h := NewHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	s := h.Snapshot()
+	bins := s.Distribution(10)
+	if len(bins) < 10 {
+		t.Fatalf("Distribution(10) returned %d bins", len(bins))
+	}
+	var total int64
+	for i, b := range bins {
+		total += b.Count
+		if b.High < b.Low {
+			t.Errorf("bin %d [%v, %v] inverted", i, b.Low, b.High)
+		}
+		if i > 0 && b.Low != bins[i-1].High {
+			t.Errorf("bin %d starts at %v, previous ends at %v", i, b.Low, bins[i-1].High)
+		}
+	}
+	if total != s.Count {
+		t.Errorf("Distribution total %d, want %d", total, s.Count)
+	}
+	if got := NewHistogram().Snapshot().Distribution(10); got != nil {
+		t.Errorf("empty Distribution = %v, want nil", got)
+	}
+}
@@ -0,0 +1,183 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// HTTPVersion 发送请求使用的HTTP协议
+type HTTPVersion string
+
+const (
+	HTTP1 HTTPVersion = "http1" // 只使用HTTP/1.1
+	HTTP2 HTTPVersion = "http2" // HTTPS通过ALPN协商HTTP/2，服务端不支持时回退到HTTP/1.1；HTTP仍使用HTTP/1.1
+	H2C   HTTPVersion = "h2c"   // HTTP直接使用明文的HTTP/2（prior knowledge），HTTPS只使用HTTP/2
+)
+
+// ProtocolConfig HTTP协议和HTTP/2连接的设置
+type ProtocolConfig struct {
+	Version HTTPVersion
+	// HTTP/2的连接数和每个连接上的最大并发流数。都为0时由连接池按需建立连接，压测开始时同时发出的请求
+	// 可能各自建立连接，流数达到服务端的限制时也会建立新连接。指定任意一个时使用固定数量的连接
+	// （Conns 为0时为1个），并发流都占满时请求等待空闲的流，等待的时间计入延迟。
+	Conns   int
+	Streams int
+}
+
+// ParseHTTPVersion 解析HTTP协议的名字
+func ParseHTTPVersion(name string) (HTTPVersion, error) {
+	switch v := HTTPVersion(name); v {
+	case HTTP1, HTTP2, H2C:
+		return v, nil
+	}
+	return "", fmt.Errorf("不支持的HTTP协议 %q，可选 http1、http2、h2c", name)
+}
+
+// createRoundTripper 按协议设置创建发送请求的连接池
+func (w *Worker) createRoundTripper() http.RoundTripper {
+	if w.protocol.Version == HTTP1 || (w.protocol.Conns == 0 && w.protocol.Streams == 0) {
+		return w.createTransport()
+	}
+
+	conns := w.protocol.Conns
+	if conns == 0 {
+		conns = 1
+	}
+	p := &streamPool{conns: make([]*streamConn, conns)}
+	for i := range p.conns {
+		// 每个连接池只允许一个连接，HTTP/2的请求都在这个连接上多路复用
+		transport := w.createTransport()
+		transport.MaxConnsPerHost = 1
+		c := &streamConn{transport: transport}
+		if w.protocol.Streams > 0 {
+			c.slots = make(chan struct{}, w.protocol.Streams)
+		}
+		p.conns[i] = c
+	}
+	return p
+}
+
+// createTransport 创建一个新的连接池
+func (w *Worker) createTransport() *http.Transport {
+	transport := createTransport(w.srcIP)
+	transport.TLSClientConfig = cloneTLSConfig(w.tlsConfig)
+	w.applyConnReuse(transport)
+	var protocols http.Protocols
+	switch w.protocol.Version {
+	case HTTP2:
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	case H2C:
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		protocols.SetHTTP1(true)
+	}
+	transport.Protocols = &protocols
+	return transport
+}
+
+// streamPool 固定数量的HTTP/2连接，请求轮流发往各个连接，每个连接上的并发流数不超过限制
+type streamPool struct {
+	conns []*streamConn
+	next  atomic.Uint64
+}
+
+// streamConn 只有一个连接的连接池，slots 的容量为最大并发流数，为nil时不限制
+type streamConn struct {
+	transport *http.Transport
+	slots     chan struct{}
+}
+
+// RoundTrip 实现 http.RoundTripper。从下一个连接开始找有空闲流的连接，都没有时等待下一个连接的空闲流。
+func (p *streamPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := p.next.Add(1)
+	n := uint64(len(p.conns))
+	for i := uint64(0); i < n; i++ {
+		c := p.conns[(start+i)%n]
+		if c.slots == nil {
+			return c.transport.RoundTrip(req)
+		}
+		select {
+		case c.slots <- struct{}{}:
+			return c.roundTrip(req)
+		default:
+		}
+	}
+
+	c := p.conns[start%n]
+	select {
+	case c.slots <- struct{}{}:
+		return c.roundTrip(req)
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+}
+
+// CloseIdleConnections 关闭所有连接池中的空闲连接
+func (p *streamPool) CloseIdleConnections() {
+	for _, c := range p.conns {
+		c.transport.CloseIdleConnections()
+	}
+}
+
+// roundTrip 在已经占用一个流的情况下发送请求，响应体关闭时释放该流
+func (c *streamConn) roundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := c.transport.RoundTrip(req)
+	if err != nil {
+		<-c.slots
+		return nil, err
+	}
+	resp.Body = &streamBody{ReadCloser: resp.Body, release: func() { <-c.slots }}
+	return resp, nil
+}
+
+// streamBody 关闭时释放所占用的流的响应体
+type streamBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *streamBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// Protocol 响应使用的HTTP协议版本
+type Protocol int
+
+const (
+	ProtocolHTTP10 Protocol = iota
+	ProtocolHTTP11
+	ProtocolHTTP2
+	ProtocolOther
+	protocolCount
+)
+
+var protocolNames = [protocolCount]string{"HTTP/1.0", "HTTP/1.1", "HTTP/2.0", "other"}
+
+// String 返回协议版本的名字，与 http.Response.Proto 相同
+func (p Protocol) String() string {
+	return protocolNames[p]
+}
+
+// Protocols 所有协议版本
+var Protocols = []Protocol{ProtocolHTTP10, ProtocolHTTP11, ProtocolHTTP2, ProtocolOther}
+
+// responseProtocol 返回响应使用的协议版本
+func responseProtocol(resp *http.Response) Protocol {
+	switch {
+	case resp.ProtoMajor == 2:
+		return ProtocolHTTP2
+	case resp.ProtoMajor == 1 && resp.ProtoMinor == 1:
+		return ProtocolHTTP11
+	case resp.ProtoMajor == 1 && resp.ProtoMinor == 0:
+		return ProtocolHTTP10
+	}
+	return ProtocolOther
+}
@@ -0,0 +1,49 @@
+package worker
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+)
+
+// SessionConfig 虚拟用户的会话设置。并发模式下每个工作协程是一个虚拟用户，
+// QPS模式下每个发送协程是一个虚拟用户。
+type SessionConfig struct {
+	Cookies bool // 每个虚拟用户有自己的Cookie jar，保存响应设置的Cookie并在之后的请求中带上
+	OwnPool bool // 每个虚拟用户使用自己的连接池，否则所有虚拟用户共用一个连接池
+}
+
+// session 一个虚拟用户的会话，包括从响应中提取的变量和发送请求的HTTP客户端
+type session struct {
+	vars    map[string]string
+	client  *http.Client
+	ownPool bool
+	// 快速路径下上一个请求留下的、可以复用的各阶段耗时的记录，见 beginRequest
+	trace *requestTrace
+}
+
+// newSession 创建一个虚拟用户的会话，不需要Cookie和独立连接池时直接使用共享的客户端
+func (w *Worker) newSession() *session {
+	s := &session{vars: make(map[string]string), client: w.client, ownPool: w.session.OwnPool}
+	if !w.session.Cookies && !w.session.OwnPool {
+		return s
+	}
+
+	transport := w.client.Transport
+	if w.session.OwnPool {
+		transport = w.createRoundTripper()
+	}
+	s.client = w.newClient(transport)
+	if w.session.Cookies {
+		// 不指定公共后缀列表时只按域名精确匹配，对压测来说足够
+		jar, _ := cookiejar.New(nil)
+		s.client.Jar = jar
+	}
+	return s
+}
+
+// close 虚拟用户退出时关闭自己连接池中的空闲连接
+func (s *session) close() {
+	if s.ownPool {
+		s.client.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stage 是负载曲线中的一个阶段
+type Stage struct {
+	Duration time.Duration // 阶段持续时间
+	Target   int           // 阶段的目标QPS
+}
+
+// StageMode 阶段之间的过渡方式
+type StageMode string
+
+const (
+	// StageModeRamp 在阶段内从上一阶段的目标线性过渡到本阶段的目标，第一个阶段从0开始
+	StageModeRamp StageMode = "ramp"
+	// StageModeStep 进入阶段时直接切换到本阶段的目标
+	StageModeStep StageMode = "step"
+)
+
+// ParseStageMode 解析阶段过渡方式
+func ParseStageMode(mode string) (StageMode, error) {
+	switch StageMode(mode) {
+	case StageModeRamp, StageModeStep:
+		return StageMode(mode), nil
+	}
+	return "", fmt.Errorf("未知的阶段模式 %q，可选值为 ramp 或 step", mode)
+}
+
+// ParseStages 解析形如 "30s:100,60s:1000,30s:0" 的阶段描述
+func ParseStages(spec string) ([]Stage, error) {
+	var stages []Stage
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("无效的阶段 %q，格式应为 时长:QPS", item)
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(parts[0]))
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("无效的阶段时长 %q", parts[0])
+		}
+		target, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || target < 0 {
+			return nil, fmt.Errorf("无效的阶段QPS %q", parts[1])
+		}
+		stages = append(stages, Stage{Duration: duration, Target: target})
+	}
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("至少需要一个阶段")
+	}
+	return stages, nil
+}
+
+// LoadStagesFile 从文件中读取阶段描述，每行一个或多个以逗号分隔的阶段，#开头的行为注释
+func LoadStagesFile(path string) ([]Stage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var items []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		items = append(items, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading file %s: %v", path, err)
+	}
+	return ParseStages(strings.Join(items, ","))
+}
+
+// StagesDuration 返回所有阶段的总时长
+func StagesDuration(stages []Stage) time.Duration {
+	var total time.Duration
+	for _, s := range stages {
+		total += s.Duration
+	}
+	return total
+}
+
+// loadProfile 描述QPS模式下目标速率随时间的变化
+type loadProfile struct {
+	stages []Stage
+	mode   StageMode
+}
+
+// constantProfile 创建一个固定QPS的负载曲线
+func constantProfile(qps int, duration time.Duration) *loadProfile {
+	return &loadProfile{
+		stages: []Stage{{Duration: duration, Target: qps}},
+		mode:   StageModeStep,
+	}
+}
+
+// rateAt 返回压测开始后 elapsed 时刻的目标QPS
+func (p *loadProfile) rateAt(elapsed time.Duration) float64 {
+	from := 0.0
+	for _, s := range p.stages {
+		if elapsed < s.Duration {
+			if p.mode == StageModeStep {
+				return float64(s.Target)
+			}
+			progress := float64(elapsed) / float64(s.Duration)
+			return from + (float64(s.Target)-from)*progress
+		}
+		elapsed -= s.Duration
+		from = float64(s.Target)
+	}
+	return 0
+}
+
+// maxRate 返回负载曲线中的最大目标QPS
+func (p *loadProfile) maxRate() int {
+	maxRate := 0
+	for _, s := range p.stages {
+		if s.Target > maxRate {
+			maxRate = s.Target
+		}
+	}
+	return maxRate
+}
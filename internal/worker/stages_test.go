@@ -0,0 +1,111 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseStages(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []Stage
+		wantErr bool
+	}{
+		{spec: "30s:100", want: []Stage{{30 * time.Second, 100}}},
+		{spec: "30s:100,1m:1000,30s:0", want: []Stage{{30 * time.Second, 100}, {time.Minute, 1000}, {30 * time.Second, 0}}},
+		{spec: " 10s : 5 , 1m30s:7 ", want: []Stage{{10 * time.Second, 5}, {90 * time.Second, 7}}},
+		{spec: "10s:5,,", want: []Stage{{10 * time.Second, 5}}},
+		{spec: "500ms:0", want: []Stage{{500 * time.Millisecond, 0}}},
+		{spec: "", wantErr: true},
+		{spec: " , ", wantErr: true},
+		{spec: "30s", wantErr: true},
+		{spec: "30:100", wantErr: true},
+		{spec: "0s:100", wantErr: true},
+		{spec: "-5s:100", wantErr: true},
+		{spec: "30s:-1", wantErr: true},
+		{spec: "30s:1.5", wantErr: true},
+		{spec: "30s:abc", wantErr: true},
+		{spec: "30s:100,bad", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseStages(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseStages(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !slices.Equal(got, tt.want) {
+			t.Errorf("ParseStages(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseStageMode(t *testing.T) {
+	for _, mode := range []string{"ramp", "step"} {
+		if got, err := ParseStageMode(mode); err != nil || string(got) != mode {
+			t.Errorf("ParseStageMode(%q) = %q, %v", mode, got, err)
+		}
+	}
+	for _, mode := range []string{"", "Ramp", "linear"} {
+		if _, err := ParseStageMode(mode); err == nil {
+			t.Errorf("ParseStageMode(%q) succeeded, want error", mode)
+		}
+	}
+}
+
+func TestLoadStagesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stages.txt")
+	content := "# 预热\n10s:100\n\n  20s:200, 30s:0  \n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadStagesFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Stage{{10 * time.Second, 100}, {20 * time.Second, 200}, {30 * time.Second, 0}}
+	if !slices.Equal(got, want) {
+		t.Errorf("LoadStagesFile() = %v, want %v", got, want)
+	}
+	if d := StagesDuration(got); d != time.Minute {
+		t.Errorf("StagesDuration() = %v, want 1m", d)
+	}
+	if _, err := LoadStagesFile(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("LoadStagesFile(missing) succeeded, want error")
+	}
+}
+
+func TestLoadProfileRateAt(t *testing.T) {
+	stages := []Stage{{10 * time.Second, 100}, {10 * time.Second, 300}, {10 * time.Second, 0}}
+	tests := []struct {
+		mode    StageMode
+		elapsed time.Duration
+		want    float64
+	}{
+		{StageModeRamp, 0, 0},
+		{StageModeRamp, 5 * time.Second, 50},
+		{StageModeRamp, 10 * time.Second, 100},
+		{StageModeRamp, 15 * time.Second, 200},
+		{StageModeRamp, 25 * time.Second, 150},
+		{StageModeRamp, 30 * time.Second, 0},
+		{StageModeStep, 0, 100},
+		{StageModeStep, 9 * time.Second, 100},
+		{StageModeStep, 10 * time.Second, 300},
+		{StageModeStep, 29 * time.Second, 0},
+		{StageModeStep, time.Hour, 0},
+	}
+	for _, tt := range tests {
+		p := &loadProfile{stages: stages, mode: tt.mode}
+		if got := p.rateAt(tt.elapsed); got != tt.want {
+			t.Errorf("%s rateAt(%v) = %v, want %v", tt.mode, tt.elapsed, got, tt.want)
+		}
+	}
+	if got := (&loadProfile{stages: stages}).maxRate(); got != 300 {
+		t.Errorf("maxRate() = %d, want 300", got)
+	}
+	if got := constantProfile(50, time.Minute).rateAt(30 * time.Second); got != 50 {
+		t.Errorf("constantProfile rateAt() = %v, want 50", got)
+	}
+}
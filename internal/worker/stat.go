@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"os"
 	"sort"
-	"sync"
+	"strings"
 	"sync/atomic"
 	"time"
 )
@@ -14,35 +14,294 @@ type RequestStats struct {
 	FailedRequests     int64
 	IntervalErrorCount int64 // 区间内错误数，每次收集统计后重置为0
 	TimeoutRequests    int64
-	TotalLatency       time.Duration
+	TotalLatency       time.Duration // 服务时间：从实际发出请求开始计算
 	MinLatency         time.Duration
 	MaxLatency         time.Duration
-	RequestsPerSec     float64
-	TotalBytes         int64
-	// 用于计算分位数的延迟数组
-	Latencies []time.Duration
-	mu        sync.Mutex
+	// 响应时间：从计划发送时间开始计算，包含请求在客户端排队等待的时间，
+	// 用于修正QPS模式下的协调遗漏（coordinated omission）问题
+	TotalResponseTime time.Duration
+	MinResponseTime   time.Duration
+	MaxResponseTime   time.Duration
+	RequestsPerSec    float64
+	TotalBytes        int64
+	// QPS模式下调度器计划发送的请求总数和当前的目标QPS
+	ScheduledRequests int64
+	TargetQPS         int64
+	// 实际发出的请求总数和正在等待响应的请求数
+	SentRequests     int64
+	InFlightRequests int64
+	// 按HTTP状态码统计的响应数，下标为状态码
+	statusCounts [maxStatusCode + 1]int64
+	// 按HTTP协议版本统计的响应数，下标为 Protocol
+	protocolCounts [protocolCount]int64
+	// 建立和关闭的连接数，以及复用已有连接的请求数
+	ConnsOpened int64
+	ConnsClosed int64
+	connsReused int64
+	// 成功的TLS握手中完整握手和复用会话的次数
+	tlsFull    int64
+	tlsResumed int64
+	// 按错误类型统计的失败请求数，下标为 ErrorClass
+	errorCounts [errorClassCount]int64
+	// 各条断言失败的次数，与 assertionExprs 一一对应
+	assertionExprs    []string
+	assertionFailures []int64
+	// 压测的开始和结束时间
+	StartTime time.Time
+	EndTime   time.Time
+	// 计算每秒请求数使用的压测时长，压测被中断时为从开始到中断的时间
+	Duration    time.Duration
+	Interrupted bool
+	// 用于计算分位数的HDR直方图，记录整个压测期间的所有请求
+	LatencyHistogram      *Histogram
+	ResponseTimeHistogram *Histogram
+	// 成功请求各阶段（DNS解析、TCP连接、TLS握手、首字节、读取响应体）的耗时直方图，下标为 Phase
+	PhaseHistograms [phaseCount]*Histogram
+	// 压测客户端自身的状态，只有整体的统计信息有，单个接口的为nil
+	Client *ClientHealth
+	// 多接口压测时单个接口的统计信息会同时累加到整体的统计信息 parent 中
+	parent *RequestStats
+}
+
+// NewRequestStats 创建一个新的请求统计实例
+func NewRequestStats() *RequestStats {
+	rs := &RequestStats{
+		MinLatency:            time.Hour,
+		MaxLatency:            0,
+		MinResponseTime:       time.Hour,
+		LatencyHistogram:      NewHistogram(),
+		ResponseTimeHistogram: NewHistogram(),
+	}
+	for i := range rs.PhaseHistograms {
+		rs.PhaseHistograms[i] = NewHistogram()
+	}
+	return rs
+}
+
+// newChild 创建一个单个接口的统计实例，记录的数据同时累加到 rs 中
+func (rs *RequestStats) newChild() *RequestStats {
+	child := NewRequestStats()
+	child.parent = rs
+	child.initAssertions(rs.assertionExprs)
+	return child
 }
 
 type SecondStats struct {
 	Timestamp    time.Time
+	TargetQPS    int64 // 当秒调度器计划发送的请求数，即目标QPS
 	RequestCount int64
 	ErrorCount   int64
 	AvgLatency   time.Duration
 	P75Latency   time.Duration
 	P90Latency   time.Duration
 	P99Latency   time.Duration
+	// 从计划发送时间起计算的响应时间
+	AvgResponseTime time.Duration
+	P75ResponseTime time.Duration
+	P90ResponseTime time.Duration
+	P99ResponseTime time.Duration
+	// 当秒各阶段的平均耗时，下标为 Phase，当秒没有发生的阶段为0
+	PhaseAvg [phaseCount]time.Duration
+	// 当秒按错误类型统计的失败请求数，下标为 ErrorClass
+	ErrorsByClass [errorClassCount]int64
+	// 当秒在客户端丢弃的请求数和调度器的最大延迟
+	Dropped         int64
+	MaxSchedulerLag time.Duration
+}
+
+// maxStatusCode 单独统计的最大HTTP状态码，超出范围的状态码记为0
+const maxStatusCode = 599
+
+// RecordStatus 记录一个响应的HTTP状态码
+func (rs *RequestStats) RecordStatus(code int) {
+	if code < 0 || code > maxStatusCode {
+		code = 0
+	}
+	for s := rs; s != nil; s = s.parent {
+		atomic.AddInt64(&s.statusCounts[code], 1)
+	}
+}
+
+// StatusCounts 返回按HTTP状态码统计的响应数
+func (rs *RequestStats) StatusCounts() map[int]int64 {
+	counts := make(map[int]int64)
+	for code := range rs.statusCounts {
+		if c := atomic.LoadInt64(&rs.statusCounts[code]); c > 0 {
+			counts[code] = c
+		}
+	}
+	return counts
+}
+
+// RecordProtocol 记录一个响应使用的HTTP协议版本
+func (rs *RequestStats) RecordProtocol(p Protocol) {
+	for s := rs; s != nil; s = s.parent {
+		atomic.AddInt64(&s.protocolCounts[p], 1)
+	}
+}
+
+// ProtocolCount 返回使用某个HTTP协议版本的响应数
+func (rs *RequestStats) ProtocolCount(p Protocol) int64 {
+	return atomic.LoadInt64(&rs.protocolCounts[p])
+}
+
+// RecordConnReused 记录一个请求复用了已有的连接
+func (rs *RequestStats) RecordConnReused() {
+	for s := rs; s != nil; s = s.parent {
+		atomic.AddInt64(&s.connsReused, 1)
+	}
+}
+
+// ConnsReused 返回复用已有连接的请求数
+func (rs *RequestStats) ConnsReused() int64 {
+	return atomic.LoadInt64(&rs.connsReused)
+}
+
+// RecordTLSHandshake 记录一次成功的TLS握手，resumed 表示复用了之前的会话
+func (rs *RequestStats) RecordTLSHandshake(resumed bool) {
+	for s := rs; s != nil; s = s.parent {
+		if resumed {
+			atomic.AddInt64(&s.tlsResumed, 1)
+		} else {
+			atomic.AddInt64(&s.tlsFull, 1)
+		}
+	}
+}
+
+// TLSHandshakes 返回完整握手和复用会话的握手次数
+func (rs *RequestStats) TLSHandshakes() (full, resumed int64) {
+	return atomic.LoadInt64(&rs.tlsFull), atomic.LoadInt64(&rs.tlsResumed)
+}
+
+// AssertionFailure 一条断言失败的次数
+type AssertionFailure struct {
+	Expr  string
+	Count int64
+}
+
+// initAssertions 设置需要统计失败次数的断言
+func (rs *RequestStats) initAssertions(exprs []string) {
+	rs.assertionExprs = exprs
+	rs.assertionFailures = make([]int64, len(exprs))
+}
+
+// RecordAssertionFailure 记录第 index 条断言失败，同时按断言失败类型记录失败请求
+func (rs *RequestStats) RecordAssertionFailure(index int) {
+	for s := rs; s != nil; s = s.parent {
+		atomic.AddInt64(&s.assertionFailures[index], 1)
+	}
+	rs.RecordError(ErrorAssertion)
+}
+
+// AssertionFailures 返回各条断言失败的次数
+func (rs *RequestStats) AssertionFailures() []AssertionFailure {
+	failures := make([]AssertionFailure, len(rs.assertionExprs))
+	for i, expr := range rs.assertionExprs {
+		failures[i] = AssertionFailure{Expr: expr, Count: atomic.LoadInt64(&rs.assertionFailures[i])}
+	}
+	return failures
+}
+
+// RecordError 按错误类型记录失败请求，超时类错误同时计入超时请求数
+func (rs *RequestStats) RecordError(class ErrorClass) {
+	for s := rs; s != nil; s = s.parent {
+		atomic.AddInt64(&s.FailedRequests, 1)     // 总错误数
+		atomic.AddInt64(&s.IntervalErrorCount, 1) // 区间错误数
+		atomic.AddInt64(&s.errorCounts[class], 1)
+		if class.IsTimeout() {
+			atomic.AddInt64(&s.TimeoutRequests, 1)
+		}
+	}
+}
+
+// ErrorCount 返回某种错误类型的失败请求数
+func (rs *RequestStats) ErrorCount(class ErrorClass) int64 {
+	return atomic.LoadInt64(&rs.errorCounts[class])
+}
+
+// RecordSuccess 记录成功请求的服务时间、响应时间和传输字节数
+func (rs *RequestStats) RecordSuccess(latency, responseTime time.Duration, bytes int64) {
+	for s := rs; s != nil; s = s.parent {
+		atomic.AddInt64(&s.TotalRequests, 1)
+		atomic.AddInt64(&s.TotalBytes, bytes)
+
+		updateMin((*int64)(&s.MinLatency), int64(latency))
+		updateMax((*int64)(&s.MaxLatency), int64(latency))
+		atomic.AddInt64((*int64)(&s.TotalLatency), int64(latency))
+
+		updateMin((*int64)(&s.MinResponseTime), int64(responseTime))
+		updateMax((*int64)(&s.MaxResponseTime), int64(responseTime))
+		atomic.AddInt64((*int64)(&s.TotalResponseTime), int64(responseTime))
+
+		s.LatencyHistogram.Record(latency)
+		s.ResponseTimeHistogram.Record(responseTime)
+	}
+}
+
+// recordPhase 记录成功请求一个阶段的耗时
+func (rs *RequestStats) recordPhase(phase Phase, d time.Duration) {
+	for s := rs; s != nil; s = s.parent {
+		s.PhaseHistograms[phase].Record(d)
+	}
+}
+
+// requestStarted 记录一个请求已发出，请求结束时需要调用 requestFinished
+func (rs *RequestStats) requestStarted() {
+	for s := rs; s != nil; s = s.parent {
+		atomic.AddInt64(&s.SentRequests, 1)
+		atomic.AddInt64(&s.InFlightRequests, 1)
+	}
+}
+
+// requestFinished 记录一个请求已结束
+func (rs *RequestStats) requestFinished() {
+	for s := rs; s != nil; s = s.parent {
+		atomic.AddInt64(&s.InFlightRequests, -1)
+	}
+}
+
+// ErrorRate 返回失败请求占全部请求的比例
+func (rs *RequestStats) ErrorRate() float64 {
+	failed := atomic.LoadInt64(&rs.FailedRequests)
+	total := atomic.LoadInt64(&rs.TotalRequests) + failed
+	if total == 0 {
+		return 0
+	}
+	return float64(failed) / float64(total)
+}
+
+// updateMin 使用CAS将addr更新为较小的值
+func updateMin(addr *int64, value int64) {
+	for {
+		old := atomic.LoadInt64(addr)
+		if value >= old {
+			return
+		}
+		if atomic.CompareAndSwapInt64(addr, old, value) {
+			return
+		}
+	}
 }
 
-// RecordError 记录错误请求
-func (rs *RequestStats) RecordError() {
-	atomic.AddInt64(&rs.FailedRequests, 1)     // 总错误数
-	atomic.AddInt64(&rs.IntervalErrorCount, 1) // 区间错误数
+// updateMax 使用CAS将addr更新为较大的值
+func updateMax(addr *int64, value int64) {
+	for {
+		old := atomic.LoadInt64(addr)
+		if value <= old {
+			return
+		}
+		if atomic.CompareAndSwapInt64(addr, old, value) {
+			return
+		}
+	}
 }
 
 // PrintStats 打印请求统计信息
 func (rs *RequestStats) PrintStats() {
 	fmt.Printf("\n压测结果:\n")
+	if rs.Interrupted {
+		fmt.Printf("（压测被中断，结果只包含前 %.1f 秒）\n", rs.Duration.Seconds())
+	}
 	fmt.Printf("总请求数: %d\n", rs.TotalRequests)
 	fmt.Printf("失败请求数: %d\n", rs.FailedRequests)
 	fmt.Printf("超时请求数: %d\n", rs.TimeoutRequests)
@@ -52,27 +311,195 @@ func (rs *RequestStats) PrintStats() {
 		fmt.Printf("最小延迟: %v\n", rs.MinLatency)
 		fmt.Printf("最大延迟: %v\n", rs.MaxLatency)
 		fmt.Printf("平均延迟: %v\n", time.Duration(int64(rs.TotalLatency)/rs.TotalRequests))
+		fmt.Printf("最小响应时间(含排队): %v\n", rs.MinResponseTime)
+		fmt.Printf("最大响应时间(含排队): %v\n", rs.MaxResponseTime)
+		fmt.Printf("平均响应时间(含排队): %v\n", time.Duration(int64(rs.TotalResponseTime)/rs.TotalRequests))
 		fmt.Printf("总传输字节: %d\n", rs.TotalBytes)
+		rs.printPercentiles()
 	} else {
 		fmt.Println("没有成功的请求，无法计算延迟统计")
 	}
+	rs.printConnections()
+	rs.printTLSHandshakes()
+	rs.printStatusAndErrors()
+	if rs.Client != nil {
+		rs.Client.print()
+	}
+}
+
+// printConnections 打印建立、关闭和复用连接的次数
+func (rs *RequestStats) printConnections() {
+	opened, closed := atomic.LoadInt64(&rs.ConnsOpened), atomic.LoadInt64(&rs.ConnsClosed)
+	reused := rs.ConnsReused()
+	if opened+reused == 0 {
+		return
+	}
+	fmt.Printf("\n连接:\n")
+	fmt.Printf("  新建连接 %10d\n", opened)
+	fmt.Printf("  关闭连接 %10d\n", closed)
+	fmt.Printf("  复用连接 %10d  %6.2f%%\n", reused, float64(reused)*100/float64(opened+reused))
 }
 
-// SecondStatsCollector 负责收集和记录每秒的统计信息
+// printTLSHandshakes 有TLS握手时打印完整握手和复用会话的次数
+func (rs *RequestStats) printTLSHandshakes() {
+	full, resumed := rs.TLSHandshakes()
+	if full+resumed == 0 {
+		return
+	}
+	fmt.Printf("\nTLS握手:\n")
+	fmt.Printf("  完整握手 %10d\n", full)
+	fmt.Printf("  会话复用 %10d  %6.2f%%\n", resumed, float64(resumed)*100/float64(full+resumed))
+}
+
+// printStatusAndErrors 打印响应状态码的分布和失败请求的错误分类
+func (rs *RequestStats) printStatusAndErrors() {
+	counts := rs.StatusCounts()
+	if len(counts) > 0 {
+		codes := make([]int, 0, len(counts))
+		for code := range counts {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		fmt.Printf("\n响应状态码:\n")
+		for _, code := range codes {
+			fmt.Printf("  %-6d %10d\n", code, counts[code])
+		}
+		fmt.Printf("\n响应协议:\n")
+		for _, p := range Protocols {
+			if n := rs.ProtocolCount(p); n > 0 {
+				fmt.Printf("  %-10s %10d\n", p, n)
+			}
+		}
+	}
+
+	if rs.FailedRequests == 0 {
+		return
+	}
+	fmt.Printf("\n错误分类:\n")
+	for _, class := range ErrorClasses {
+		if n := rs.ErrorCount(class); n > 0 {
+			fmt.Printf("  %-16s %-14s %10d %7.2f%%\n", class, class.Label(), n, float64(n)*100/float64(rs.FailedRequests))
+		}
+	}
+
+	if rs.ErrorCount(ErrorAssertion) == 0 {
+		return
+	}
+	fmt.Printf("\n断言失败:\n")
+	for _, f := range rs.AssertionFailures() {
+		fmt.Printf("  %-40s %10d\n", f.Expr, f.Count)
+	}
+}
+
+// reportPercentiles 是最终报告中展示的分位数
+var reportPercentiles = []struct {
+	label    string
+	quantile float64
+}{
+	{"p50", 0.50},
+	{"p90", 0.90},
+	{"p99", 0.99},
+	{"p99.9", 0.999},
+	{"p99.99", 0.9999},
+}
+
+// distributionBins 是最终报告中延迟分布图的区间数
+const distributionBins = 10
+
+// printPercentiles 打印整个压测期间的延迟分位数、标准差和分布图
+func (rs *RequestStats) printPercentiles() {
+	latency := rs.LatencyHistogram.Snapshot()
+	if latency.Count == 0 {
+		return
+	}
+	responseTime := rs.ResponseTimeHistogram.Snapshot()
+
+	fmt.Printf("延迟标准差: %v\n", latency.StdDev())
+	fmt.Printf("响应时间标准差(含排队): %v\n", responseTime.StdDev())
+
+	fmt.Printf("\n延迟分布(全程):\n")
+	fmt.Printf("  %-8s %14s %14s\n", "分位数", "延迟", "响应时间(含排队)")
+	for _, p := range reportPercentiles {
+		fmt.Printf("  %-8s %14v %14v\n", p.label, latency.Quantile(p.quantile), responseTime.Quantile(p.quantile))
+	}
+
+	fmt.Printf("\n延迟直方图(全程):\n")
+	printDistribution(latency.Distribution(distributionBins), latency.Count)
+
+	rs.printPhases()
+}
+
+// printPhases 打印各阶段的耗时分布，连接复用时DNS解析、TCP连接和TLS握手的次数会少于请求数
+func (rs *RequestStats) printPhases() {
+	fmt.Printf("\n阶段耗时(全程):\n")
+	fmt.Printf("  %-10s %10s %12s %12s %12s %12s %12s\n", "阶段", "次数", "平均", "p50", "p90", "p99", "最大")
+	for _, phase := range Phases {
+		s := rs.PhaseHistograms[phase].Snapshot()
+		if s.Count == 0 {
+			fmt.Printf("  %-10s %10d %12s %12s %12s %12s %12s\n", phase.Label(), 0, "-", "-", "-", "-", "-")
+			continue
+		}
+		fmt.Printf("  %-10s %10d %12v %12v %12v %12v %12v\n", phase.Label(), s.Count,
+			s.Mean().Round(time.Microsecond), s.Quantile(0.50).Round(time.Microsecond),
+			s.Quantile(0.90).Round(time.Microsecond), s.Quantile(0.99).Round(time.Microsecond),
+			time.Duration(s.Max).Round(time.Microsecond))
+	}
+}
+
+// printDistribution 以文本柱状图的形式打印延迟分布
+func printDistribution(bins []HistogramBin, total int64) {
+	const barWidth = 40
+
+	var maxCount int64
+	for _, bin := range bins {
+		if bin.Count > maxCount {
+			maxCount = bin.Count
+		}
+	}
+	if maxCount == 0 {
+		return
+	}
+
+	for _, bin := range bins {
+		bar := int(bin.Count * barWidth / maxCount)
+		if bar == 0 && bin.Count > 0 {
+			bar = 1
+		}
+		fmt.Printf("  %12v - %-12v %8d %6.2f%% |%s\n",
+			bin.Low.Round(time.Microsecond), bin.High.Round(time.Microsecond), bin.Count,
+			float64(bin.Count)*100/float64(total),
+			strings.Repeat("#", bar))
+	}
+}
+
+// SecondStatsCollector 负责收集和记录每秒的统计信息。
+// 每秒的统计信息总是保存在内存中供报告使用，启用时还会写入 stats.csv 文件。
 type SecondStatsCollector struct {
 	enabled     bool
 	statsFile   *os.File
 	statsTicker *time.Ticker
 	stats       *RequestStats
 	stopChan    chan struct{}
+	doneChan    chan struct{}
+	// 每秒统计信息的序列
+	series []*SecondStats
+	// 上一秒的直方图快照，用于计算当秒的增量
+	lastLatency      *HistogramSnapshot
+	lastResponseTime *HistogramSnapshot
+	lastPhases       [phaseCount]*HistogramSnapshot
+	lastScheduled    int64
+	lastErrors       [errorClassCount]int64
+	lastDropped      int64
+	lastLag          *HistogramSnapshot
 }
 
-// NewSecondStatsCollector 创建一个新的每秒统计收集器
+// NewSecondStatsCollector 创建一个新的每秒统计收集器，enabled 表示是否写入 stats.csv 文件
 func NewSecondStatsCollector(stats *RequestStats, enabled bool) (*SecondStatsCollector, error) {
 	collector := &SecondStatsCollector{
 		enabled:  enabled,
 		stats:    stats,
 		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
 	}
 
 	if enabled {
@@ -83,8 +510,12 @@ func NewSecondStatsCollector(stats *RequestStats, enabled bool) (*SecondStatsCol
 		}
 
 		// 写入CSV头
-		fmt.Fprintf(collector.statsFile, "时间点,当秒请求数,错误数量,平均延迟,p75_latency,p90_latency,p99_latency\n")
-		collector.statsTicker = time.NewTicker(time.Second)
+		fmt.Fprintf(collector.statsFile, "时间点,当秒请求数,错误数量,平均延迟,p75_latency,p90_latency,p99_latency,avg_response_time,p75_response_time,p90_response_time,p99_response_time,target_qps,avg_dns_ms,avg_connect_ms,avg_tls_ms,avg_ttfb_ms,avg_body_ms")
+		for _, class := range ErrorClasses {
+			fmt.Fprintf(collector.statsFile, ",err_%s", class)
+		}
+		fmt.Fprintf(collector.statsFile, ",client_dropped,scheduler_lag_max_ms")
+		fmt.Fprintln(collector.statsFile)
 	}
 
 	return collector, nil
@@ -92,114 +523,144 @@ func NewSecondStatsCollector(stats *RequestStats, enabled bool) (*SecondStatsCol
 
 // Start 启动统计收集
 func (c *SecondStatsCollector) Start() {
-	if !c.enabled {
-		return
-	}
+	c.statsTicker = time.NewTicker(time.Second)
 
 	go func() {
+		defer close(c.doneChan)
 		for {
 			select {
 			case <-c.stopChan:
 				return
 			case <-c.statsTicker.C:
 				if stats := c.collectStats(); stats != nil {
-					fmt.Fprintf(c.statsFile, "%s,%d,%d,%d,%d,%d,%d\n",
-						stats.Timestamp.Format("2006-01-02 15:04:05"),
-						stats.RequestCount,
-						stats.ErrorCount,
-						stats.AvgLatency.Milliseconds(),
-						stats.P75Latency.Milliseconds(),
-						stats.P90Latency.Milliseconds(),
-						stats.P99Latency.Milliseconds())
-					c.statsFile.Sync()
+					c.series = append(c.series, stats)
+					c.writeStats(stats)
 				}
 			}
 		}
 	}()
 }
 
-// Stop 停止统计收集
-func (c *SecondStatsCollector) Stop() {
+// writeStats 将一秒的统计信息写入 stats.csv 文件
+func (c *SecondStatsCollector) writeStats(stats *SecondStats) {
 	if !c.enabled {
 		return
 	}
 
+	fmt.Fprintf(c.statsFile, "%s,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d,%d",
+		stats.Timestamp.Format("2006-01-02 15:04:05"),
+		stats.RequestCount,
+		stats.ErrorCount,
+		stats.AvgLatency.Milliseconds(),
+		stats.P75Latency.Milliseconds(),
+		stats.P90Latency.Milliseconds(),
+		stats.P99Latency.Milliseconds(),
+		stats.AvgResponseTime.Milliseconds(),
+		stats.P75ResponseTime.Milliseconds(),
+		stats.P90ResponseTime.Milliseconds(),
+		stats.P99ResponseTime.Milliseconds(),
+		stats.TargetQPS)
+	for _, phase := range Phases {
+		fmt.Fprintf(c.statsFile, ",%.3f", float64(stats.PhaseAvg[phase])/float64(time.Millisecond))
+	}
+	for _, class := range ErrorClasses {
+		fmt.Fprintf(c.statsFile, ",%d", stats.ErrorsByClass[class])
+	}
+	fmt.Fprintf(c.statsFile, ",%d,%.3f", stats.Dropped, float64(stats.MaxSchedulerLag)/float64(time.Millisecond))
+	fmt.Fprintln(c.statsFile)
+	c.statsFile.Sync()
+}
+
+// Stop 停止统计收集，等待收集协程退出后关闭统计文件
+func (c *SecondStatsCollector) Stop() {
 	close(c.stopChan)
 	c.statsTicker.Stop()
+	<-c.doneChan
+	// 最后不足一秒的统计，压测结束或被中断时等待请求完成的时间也在其中
+	if stats := c.collectStats(); stats != nil {
+		c.series = append(c.series, stats)
+		c.writeStats(stats)
+	}
 	if c.statsFile != nil {
 		c.statsFile.Close()
 	}
 }
 
-// RecordLatency 记录请求延迟
-func (c *SecondStatsCollector) RecordLatency(latency time.Duration) {
-	if !c.enabled {
-		return
-	}
-
-	c.stats.mu.Lock()
-	c.stats.Latencies = append(c.stats.Latencies, latency)
-	c.stats.mu.Unlock()
-}
-
-// RecordError 记录错误请求
-func (c *SecondStatsCollector) RecordError() {
-	if !c.enabled {
-		return
-	}
-
-	atomic.AddInt64(&c.stats.FailedRequests, 1)     // 总错误数
-	atomic.AddInt64(&c.stats.IntervalErrorCount, 1) // 区间错误数
+// Series 返回每秒统计信息的序列，需要在 Stop 之后调用
+func (c *SecondStatsCollector) Series() []*SecondStats {
+	return c.series
 }
 
 // collectStats 收集当前秒的统计信息
 func (c *SecondStatsCollector) collectStats() *SecondStats {
-	c.stats.mu.Lock()
-	defer c.stats.mu.Unlock()
+	// 对全程直方图做快照，与上一秒的快照做差得到当秒的延迟分布
+	latencySnapshot := c.stats.LatencyHistogram.Snapshot()
+	responseTimeSnapshot := c.stats.ResponseTimeHistogram.Snapshot()
+	latency := latencySnapshot.Sub(c.lastLatency)
+	responseTime := responseTimeSnapshot.Sub(c.lastResponseTime)
+	c.lastLatency = latencySnapshot
+	c.lastResponseTime = responseTimeSnapshot
 
-	if len(c.stats.Latencies) == 0 {
-		return nil
-	}
+	// 获取并重置区间错误数，准备下一秒的统计
+	errorCount := atomic.SwapInt64(&c.stats.IntervalErrorCount, 0)
 
-	// 计算分位数
-	p75 := calculatePercentile(c.stats.Latencies, 0.75)
-	p90 := calculatePercentile(c.stats.Latencies, 0.90)
-	p99 := calculatePercentile(c.stats.Latencies, 0.99)
+	// 当秒计划发送的请求数
+	scheduled := atomic.LoadInt64(&c.stats.ScheduledRequests)
+	targetQPS := scheduled - c.lastScheduled
+	c.lastScheduled = scheduled
 
-	// 计算平均延迟
-	var totalLatency time.Duration
-	for _, l := range c.stats.Latencies {
-		totalLatency += l
+	// 各阶段当秒的平均耗时
+	var phaseAvg [phaseCount]time.Duration
+	for _, phase := range Phases {
+		snapshot := c.stats.PhaseHistograms[phase].Snapshot()
+		phaseAvg[phase] = snapshot.Sub(c.lastPhases[phase]).Mean()
+		c.lastPhases[phase] = snapshot
 	}
-	avgLatency := totalLatency / time.Duration(len(c.stats.Latencies))
 
-	stats := &SecondStats{
-		Timestamp:    time.Now(),
-		RequestCount: int64(len(c.stats.Latencies)),
-		ErrorCount:   atomic.LoadInt64(&c.stats.IntervalErrorCount), // 使用区间错误数
-		AvgLatency:   avgLatency,
-		P75Latency:   p75,
-		P90Latency:   p90,
-		P99Latency:   p99,
+	// 当秒按错误类型统计的失败请求数
+	var errorsByClass [errorClassCount]int64
+	for _, class := range ErrorClasses {
+		n := c.stats.ErrorCount(class)
+		errorsByClass[class] = n - c.lastErrors[class]
+		c.lastErrors[class] = n
 	}
 
-	// 清空延迟数组和区间错误数，准备下一秒的统计
-	c.stats.Latencies = c.stats.Latencies[:0]
-	atomic.StoreInt64(&c.stats.IntervalErrorCount, 0) // 重置区间错误数
-
-	return stats
-}
+	// 当秒在客户端丢弃的请求数和调度器的最大延迟
+	var dropped int64
+	var maxLag time.Duration
+	if h := c.stats.Client; h != nil {
+		n := atomic.LoadInt64(&h.Dropped)
+		dropped = n - c.lastDropped
+		c.lastDropped = n
+		snapshot := h.LagHistogram.Snapshot()
+		if lag := snapshot.Sub(c.lastLag); lag.Count > 0 {
+			maxLag = time.Duration(lag.Max)
+		}
+		c.lastLag = snapshot
+	}
 
-// calculatePercentile 计算延迟分位数
-func calculatePercentile(latencies []time.Duration, percentile float64) time.Duration {
-	if len(latencies) == 0 {
-		return 0
+	if latency.Count == 0 && errorCount == 0 && targetQPS == 0 {
+		return nil
 	}
 
-	sort.Slice(latencies, func(i, j int) bool {
-		return latencies[i] < latencies[j]
-	})
+	return &SecondStats{
+		Timestamp:    time.Now(),
+		TargetQPS:    targetQPS,
+		RequestCount: latency.Count,
+		ErrorCount:   errorCount,
+		AvgLatency:   latency.Mean(),
+		P75Latency:   latency.Quantile(0.75),
+		P90Latency:   latency.Quantile(0.90),
+		P99Latency:   latency.Quantile(0.99),
+
+		AvgResponseTime: responseTime.Mean(),
+		P75ResponseTime: responseTime.Quantile(0.75),
+		P90ResponseTime: responseTime.Quantile(0.90),
+		P99ResponseTime: responseTime.Quantile(0.99),
 
-	index := int(float64(len(latencies)-1) * percentile)
-	return latencies[index]
+		PhaseAvg:        phaseAvg,
+		ErrorsByClass:   errorsByClass,
+		Dropped:         dropped,
+		MaxSchedulerLag: maxLag,
+	}
 }
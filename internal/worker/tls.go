@@ -0,0 +1,122 @@
+package worker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSOptions HTTPS请求的TLS客户端设置
+type TLSOptions struct {
+	CertFile     string   // 双向TLS的客户端证书，与 KeyFile 同时指定
+	KeyFile      string   // 客户端证书的私钥
+	CAFile       string   // 校验服务端证书的CA证书，指定后代替系统的CA证书
+	ServerName   string   // SNI和校验证书时使用的服务端名字，默认为URL中的主机名
+	Insecure     bool     // 不校验服务端证书
+	MinVersion   string   // 1.0、1.1、1.2 或 1.3
+	MaxVersion   string   // 1.0、1.1、1.2 或 1.3
+	CipherSuites []string // 密码套件的名字，只对TLS 1.2及以下的版本生效
+	Resumption   bool     // 复用之前握手的会话，减少完整握手的次数
+}
+
+// tlsVersions 支持的TLS版本
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewTLSConfig 按设置创建TLS客户端配置，读取证书文件并检查版本和密码套件的名字
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.Insecure,
+	}
+
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, errors.New("客户端证书和私钥必须同时指定")
+	}
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载客户端证书失败: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.CAFile != "" {
+		data, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取CA证书失败: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("CA证书文件 %s 中没有PEM格式的证书", opts.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	var err error
+	if cfg.MinVersion, err = parseTLSVersion(opts.MinVersion); err != nil {
+		return nil, err
+	}
+	if cfg.MaxVersion, err = parseTLSVersion(opts.MaxVersion); err != nil {
+		return nil, err
+	}
+	if cfg.MinVersion != 0 && cfg.MaxVersion != 0 && cfg.MinVersion > cfg.MaxVersion {
+		return nil, fmt.Errorf("TLS最低版本 %s 高于最高版本 %s", opts.MinVersion, opts.MaxVersion)
+	}
+
+	for _, name := range opts.CipherSuites {
+		id, ok := cipherSuiteID(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("不支持的密码套件 %q", name)
+		}
+		cfg.CipherSuites = append(cfg.CipherSuites, id)
+	}
+
+	if opts.Resumption {
+		cfg.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	return cfg, nil
+}
+
+// parseTLSVersion 解析TLS版本，为空时返回0，即使用Go的默认值
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("不支持的TLS版本 %q，可选 1.0、1.1、1.2、1.3", version)
+	}
+	return v, nil
+}
+
+// cipherSuiteID 按名字查找密码套件，包括Go认为不安全的密码套件
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, suite := range suites {
+			if suite.Name == name {
+				return suite.ID, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// cloneTLSConfig 为一个连接池复制TLS配置，启用了会话复用时每个连接池使用自己的会话缓存
+func cloneTLSConfig(cfg *tls.Config) *tls.Config {
+	if cfg == nil {
+		return nil
+	}
+	clone := cfg.Clone()
+	if cfg.ClientSessionCache != nil {
+		clone.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	return clone
+}
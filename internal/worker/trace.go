@@ -0,0 +1,154 @@
+package worker
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+// Phase 一个请求的耗时阶段
+type Phase int
+
+const (
+	PhaseDNS     Phase = iota // DNS解析，只在建立新连接时发生
+	PhaseConnect              // TCP连接，只在建立新连接时发生
+	PhaseTLS                  // TLS握手，只在建立新的HTTPS连接时发生
+	PhaseTTFB                 // 首字节：请求发送完毕到收到第一个响应字节，即服务端的处理时间
+	PhaseBody                 // 读取响应体
+	phaseCount
+)
+
+// Phases 所有阶段，按请求中发生的先后顺序排列
+var Phases = []Phase{PhaseDNS, PhaseConnect, PhaseTLS, PhaseTTFB, PhaseBody}
+
+var phaseNames = [phaseCount]string{"dns", "connect", "tls", "ttfb", "body"}
+
+var phaseLabels = [phaseCount]string{"DNS解析", "TCP连接", "TLS握手", "首字节", "读取响应体"}
+
+// String 返回阶段的英文名，用于CSV和JSON报告
+func (p Phase) String() string {
+	return phaseNames[p]
+}
+
+// Label 返回阶段的中文名，用于终端输出
+func (p Phase) Label() string {
+	return phaseLabels[p]
+}
+
+// requestTrace 记录一个请求各阶段的起止时间（UnixNano）。
+// 建立连接相关的回调可能在Transport的拨号协程中触发，因此使用原子操作。
+// 拨号失败重试时，开始时间取第一次，结束时间取最后一次。成功的TLS握手直接记录到 stats 中。
+// 快速路径下确定不会再有回调的 requestTrace 留给同一个虚拟用户的下一个请求复用，复用前调用 reset。
+type requestTrace struct {
+	stats        atomic.Pointer[RequestStats]
+	retired      atomic.Pointer[trackedConn] // 本次请求是最后一个请求、响应完成后需要关闭的连接
+	ctx          context.Context             // 带有本 requestTrace 回调的 context，快速路径下随 requestTrace 一起复用
+	notIdle      atomic.Bool                 // 取得的连接不是直接从空闲连接中取出的，Transport 可能为请求发起了拨号
+	dnsStart     int64
+	dnsDone      int64
+	connectStart int64
+	connectDone  int64
+	tlsStart     int64
+	tlsDone      int64
+	gotConn      int64
+	wroteRequest int64
+	firstByte    int64
+}
+
+// newRequestTrace 创建一个记录到 stats 的 requestTrace
+func newRequestTrace(stats *RequestStats) *requestTrace {
+	t := &requestTrace{}
+	t.stats.Store(stats)
+	return t
+}
+
+// reset 清空上一个请求的记录，之后的回调记录到 stats 中
+func (t *requestTrace) reset(stats *RequestStats) {
+	t.stats.Store(stats)
+	t.retired.Store(nil)
+	t.notIdle.Store(false)
+	for _, addr := range []*int64{&t.dnsStart, &t.dnsDone, &t.connectStart, &t.connectDone,
+		&t.tlsStart, &t.tlsDone, &t.gotConn, &t.wroteRequest, &t.firstByte} {
+		atomic.StoreInt64(addr, 0)
+	}
+}
+
+// clientTrace 返回写入 requestTrace 的 httptrace 回调
+func (t *requestTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { markFirst(&t.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { markLast(&t.dnsDone) },
+		ConnectStart:         func(string, string) { markFirst(&t.connectStart) },
+		ConnectDone:          func(string, string, error) { markLast(&t.connectDone) },
+		TLSHandshakeStart:    func() { markFirst(&t.tlsStart) },
+		TLSHandshakeDone:     t.tlsHandshakeDone,
+		GotConn:              t.gotConnection,
+		WroteRequest:         func(httptrace.WroteRequestInfo) { markLast(&t.wroteRequest) },
+		GotFirstResponseByte: func() { markFirst(&t.firstByte) },
+	}
+}
+
+// tlsHandshakeDone 记录TLS握手的结束时间，握手成功时记录是否复用了会话
+func (t *requestTrace) tlsHandshakeDone(state tls.ConnectionState, err error) {
+	markLast(&t.tlsDone)
+	if stats := t.stats.Load(); err == nil && stats != nil {
+		stats.RecordTLSHandshake(state.DidResume)
+	}
+}
+
+// gotConnection 记录取得连接的时间和连接的复用，连接达到最大请求数时记下该连接
+func (t *requestTrace) gotConnection(info httptrace.GotConnInfo) {
+	markLast(&t.gotConn)
+	if !info.WasIdle {
+		t.notIdle.Store(true)
+	}
+	if stats := t.stats.Load(); info.Reused && stats != nil {
+		stats.RecordConnReused()
+	}
+	if c := asTrackedConn(info.Conn); c != nil && c.acquire() {
+		t.retired.Store(c)
+	}
+}
+
+// settled 判断HTTP/1请求结束后是否不会再有回调：每次都直接取得了空闲连接，没有发起拨号，并且已经发完请求
+func (t *requestTrace) settled() bool {
+	return atomic.LoadInt64(&t.gotConn) != 0 && atomic.LoadInt64(&t.wroteRequest) != 0 && !t.notIdle.Load()
+}
+
+// closeRetired 响应读取完毕后关闭达到最大请求数的连接
+func (t *requestTrace) closeRetired() {
+	if c := t.retired.Load(); c != nil {
+		c.Close()
+	}
+}
+
+// record 将各阶段的耗时记录到统计信息中，bodyStart 和 bodyDone 为读取响应体的起止时间。
+// 连接复用时不会发生DNS解析、TCP连接和TLS握手，这些阶段不做记录。
+func (t *requestTrace) record(rs *RequestStats, bodyStart, bodyDone time.Time) {
+	recordSpan(rs, PhaseDNS, &t.dnsStart, &t.dnsDone)
+	recordSpan(rs, PhaseConnect, &t.connectStart, &t.connectDone)
+	recordSpan(rs, PhaseTLS, &t.tlsStart, &t.tlsDone)
+	recordSpan(rs, PhaseTTFB, &t.wroteRequest, &t.firstByte)
+	rs.recordPhase(PhaseBody, bodyDone.Sub(bodyStart))
+}
+
+// recordSpan 起止时间都存在时记录该阶段的耗时
+func recordSpan(rs *RequestStats, phase Phase, start, end *int64) {
+	s, e := atomic.LoadInt64(start), atomic.LoadInt64(end)
+	if s == 0 || e == 0 || e < s {
+		return
+	}
+	rs.recordPhase(phase, time.Duration(e-s))
+}
+
+// markFirst 记录第一次触发的时间
+func markFirst(addr *int64) {
+	atomic.CompareAndSwapInt64(addr, 0, time.Now().UnixNano())
+}
+
+// markLast 记录最后一次触发的时间
+func markLast(addr *int64) {
+	atomic.StoreInt64(addr, time.Now().UnixNano())
+}
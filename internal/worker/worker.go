@@ -3,11 +3,12 @@ package worker
 import (
 	"bytes"
 	"context"
-	"errors"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -22,6 +23,8 @@ type Worker struct {
 	duration    time.Duration
 	timeout     time.Duration
 	qps         int
+	profile     *loadProfile // QPS模式下的负载曲线，为nil时为并发模式
+	arrival     ArrivalConfig
 	stats       *RequestStats
 	wg          *sync.WaitGroup
 	stopChan    chan struct{}
@@ -37,6 +40,32 @@ type Worker struct {
 	headers map[string]string
 	srcIP   string
 	client  *http.Client
+	// 限流打印失败请求的样例
+	errorLog *errorSampler
+	// 视为成功的状态码和对响应的断言
+	successStatus StatusSet
+	assertions    []*Assertion
+	// 多接口压测时按权重选择的接口，未设置时只有一个由 url、method、headers 和 generator 组成的接口
+	endpoints []Endpoint
+	picker    *endpointPicker
+	// 多步骤流程，设置后每次迭代依次发送所有步骤，代替按权重选择接口
+	flow  []Endpoint
+	steps []*EndpointStats
+	// 虚拟用户的Cookie和连接池设置
+	session SessionConfig
+	// HTTP协议和HTTP/2连接的设置
+	protocol ProtocolConfig
+	// HTTPS请求的TLS配置，为nil时使用Go的默认配置
+	tlsConfig *tls.Config
+	// 连接复用方式
+	connReuse ConnReuseConfig
+	// 压测被中断后等待正在发送的请求完成的最长时间，超过后取消这些请求
+	gracePeriod time.Duration
+	stopOnce    sync.Once
+	// 所有请求的 context，等待超过宽限期时取消
+	reqCtx context.Context
+	// 请求固定时预先构造请求，并复用读取响应体的缓冲区
+	fastPath bool
 }
 
 // createDialContext 创建一个支持指定source IP和DNS缓存的DialContext
@@ -47,8 +76,15 @@ func createDialContext(srcIP string) func(ctx context.Context, network, addr str
 			return nil, err
 		}
 
-		// 使用DNS缓存查找IP
+		// 使用DNS缓存查找IP。自定义拨号时Transport不会触发DNS相关的回调，这里手动触发以统计DNS解析耗时
+		trace := httptrace.ContextClientTrace(ctx)
+		if trace != nil && trace.DNSStart != nil {
+			trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+		}
 		ips, err := dnsCache.LookupHost(ctx, host)
+		if trace != nil && trace.DNSDone != nil {
+			trace.DNSDone(httptrace.DNSDoneInfo{Err: err})
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -98,13 +134,13 @@ func createDialContext(srcIP string) func(ctx context.Context, network, addr str
 			}
 		}
 
-		return nil, fmt.Errorf("failed after %d retries, last error: %v", maxRetries, lastErr)
+		return nil, fmt.Errorf("failed after %d retries, last error: %w", maxRetries, lastErr)
 	}
 }
 
-// createClient 创建一个新的HTTP客户端
-func createClient(srcIP string) *http.Client {
-	transport := &http.Transport{
+// createTransport 创建一个新的连接池
+func createTransport(srcIP string) *http.Transport {
+	return &http.Transport{
 		MaxIdleConns:           10000,                    // 增加最大空闲连接数
 		MaxIdleConnsPerHost:    10000,                    // 增加每个主机的最大空闲连接数
 		MaxConnsPerHost:        10000,                    // 增加每个主机的最大连接数
@@ -118,11 +154,38 @@ func createClient(srcIP string) *http.Client {
 		WriteBufferSize:        4096,                     // 写缓冲区大小
 		ReadBufferSize:         4096,                     // 读缓冲区大小
 	}
+}
 
-	return &http.Client{
-		Transport: transport,
-		// 移除全局超时，使用 context 控制单个请求超时
+// newClient 创建使用 transport 的HTTP客户端。重定向状态码视为成功时不跟随重定向，
+// 否则与默认的 http.Client 相同，最多跟随10次重定向，只检查最终响应的状态码
+func (w *Worker) newClient(transport http.RoundTripper) *http.Client {
+	client := &http.Client{Transport: transport}
+	if w.successStatus.HasRedirect() {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	return client
+}
+
+// ParseHeaders 解析形如 key1:value1,key2:value2 的头部字符串
+func ParseHeaders(headers string) map[string]string {
+	headersMap := make(map[string]string)
+	if headers != "" {
+		headerPairs := strings.Split(headers, ",")
+		for _, pair := range headerPairs {
+			pair = strings.TrimSpace(pair)
+			if pair != "" {
+				parts := strings.SplitN(pair, ":", 2)
+				if len(parts) == 2 {
+					key := strings.TrimSpace(parts[0])
+					value := strings.TrimSpace(parts[1])
+					headersMap[key] = value
+				}
+			}
+		}
 	}
+	return headersMap
 }
 
 func NewWorker(url string, concurrency int, duration time.Duration, timeout time.Duration, qps int, generator gen.RequestGenerator, enableSecondStats bool, method string, headers string, srcIP string) *Worker {
@@ -137,7 +200,8 @@ func NewWorker(url string, concurrency int, duration time.Duration, timeout time
 		maxWorkers = int32(concurrency)
 	}
 
-	stats := &RequestStats{MinLatency: time.Hour, MaxLatency: 0}
+	stats := NewRequestStats()
+	stats.Client = newClientHealth()
 	statsCollector, err := NewSecondStatsCollector(stats, enableSecondStats)
 	if err != nil {
 		fmt.Printf("创建统计收集器失败: %v\n", err)
@@ -145,24 +209,13 @@ func NewWorker(url string, concurrency int, duration time.Duration, timeout time
 	}
 
 	// 解析headers字符串
-	headersMap := make(map[string]string)
-	if headers != "" {
-		headerPairs := strings.Split(headers, ",")
-		for _, pair := range headerPairs {
-			pair = strings.TrimSpace(pair)
-			if pair != "" {
-				parts := strings.SplitN(pair, ":", 2)
-				if len(parts) == 2 {
-					key := strings.TrimSpace(parts[0])
-					value := strings.TrimSpace(parts[1])
-					headersMap[key] = value
-				}
-			}
-		}
-	}
+	headersMap := ParseHeaders(headers)
 
-	// 创建HTTP客户端
-	client := createClient(srcIP)
+	// QPS模式下默认使用固定QPS的负载曲线
+	var profile *loadProfile
+	if qps > 0 {
+		profile = constantProfile(qps, duration)
+	}
 
 	return &Worker{
 		url:            url,
@@ -170,6 +223,8 @@ func NewWorker(url string, concurrency int, duration time.Duration, timeout time
 		duration:       duration,
 		timeout:        timeout,
 		qps:            qps,
+		profile:        profile,
+		arrival:        ArrivalConfig{Process: ArrivalUniform},
 		stats:          stats,
 		wg:             &sync.WaitGroup{},
 		stopChan:       make(chan struct{}),
@@ -180,102 +235,171 @@ func NewWorker(url string, concurrency int, duration time.Duration, timeout time
 		method:         method,
 		headers:        headersMap,
 		srcIP:          srcIP,
-		client:         client,
+		errorLog:       newErrorSampler(time.Second),
+		successStatus:  DefaultSuccessStatus,
+		protocol:       ProtocolConfig{Version: HTTP1},
+		connReuse:      ConnReuseConfig{Mode: ReuseKeepAlive},
+		gracePeriod:    5 * time.Second,
+		reqCtx:         context.Background(),
+		fastPath:       true,
 	}
 }
 
-func (w *Worker) makeRequest() {
-	jsonBody, err := w.generator.Generate()
-	if err != nil {
-		w.stats.RecordError()
+// makeRequest 进行一次迭代：设置了多步骤流程时依次发送所有步骤，否则按权重选择一个接口发送一个请求。
+// intended 为计划发送时间，s 为当前虚拟用户的会话。
+func (w *Worker) makeRequest(intended time.Time, s *session) {
+	if w.steps != nil {
+		w.runFlow(intended, s)
 		return
 	}
+	w.send(w.picker.pick(), intended, s)
+}
 
-	req, err := http.NewRequest(w.method, w.url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		fmt.Printf("创建请求失败: %v\n", err)
-		w.stats.RecordError()
-		return
-	}
+// send 以虚拟用户 s 的身份向一个接口发送一个请求，返回请求是否成功，成功时将提取的变量写入会话。
+// 延迟（服务时间）从真正发出请求开始计算，响应时间从计划发送时间开始计算。
+func (w *Worker) send(ep *EndpointStats, intended time.Time, s *session) bool {
+	stats := ep.Stats
 
-	// 设置默认的Content-Type头部
-	req.Header.Set("Content-Type", "application/json")
+	// 请求固定时直接使用预先构造的请求，否则逐个生成
+	var spec *gen.RequestSpec
+	var req *http.Request
+	if ep.template != nil {
+		spec = ep.template.spec
+	} else {
+		var err error
+		spec, err = ep.spec.GenerateSpec(ep.base, s.vars)
+		if err != nil {
+			w.errorLog.log(ErrorGenerator, "生成请求失败: %v", err)
+			stats.RecordError(ErrorGenerator)
+			return false
+		}
+
+		req, err = http.NewRequest(spec.Method, spec.URL, bytes.NewBuffer(spec.Body))
+		if err != nil {
+			w.errorLog.log(ErrorGenerator, "创建请求失败: %v", err)
+			stats.RecordError(ErrorGenerator)
+			return false
+		}
 
-	// 设置用户指定的额外头部
-	for key, value := range w.headers {
-		req.Header.Set(key, value)
+		// 设置默认的Content-Type头部
+		req.Header.Set("Content-Type", "application/json")
+
+		// 设置用户指定的额外头部
+		for key, value := range spec.Headers {
+			req.Header.Set(key, value)
+		}
 	}
 
 	start := time.Now()
 
-	// 使用 context 控制单个请求的超时
-	ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
-	defer cancel()
-
-	req = req.WithContext(ctx)
-	resp, err := w.client.Do(req)
+	// 使用 context 控制单个请求的超时，并记录DNS解析、TCP连接、TLS握手、首字节等各阶段的耗时。
+	// 快速路径下尽量复用上一个请求的 httptrace 回调，不再为每个请求分配
+	var ctx context.Context
+	var trace *requestTrace
+	reusable := false // 快速路径下请求结束后 requestTrace 能否复用，见 endRequest
+	if w.fastPath {
+		var cancel context.CancelFunc
+		ctx, cancel, trace = s.beginRequest(w.reqCtx, stats, w.timeout)
+		defer func() { s.endRequest(cancel, trace, reusable) }()
+	} else {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(w.reqCtx, w.timeout)
+		defer cancel()
+		trace = newRequestTrace(stats)
+		ctx = httptrace.WithClientTrace(ctx, trace.clientTrace())
+	}
+	if ep.template != nil {
+		req = ep.template.newRequest(ctx, s.client.Jar)
+	} else {
+		req = req.WithContext(ctx)
+	}
+	stats.requestStarted()
+	defer stats.requestFinished()
+	resp, err := s.client.Do(req)
 	if err != nil {
-		if errors.Is(err, context.DeadlineExceeded) {
-			atomic.AddInt64(&w.stats.TimeoutRequests, 1)
+		if w.reqCtx.Err() != nil {
+			// 压测被中断后超过宽限期而取消的请求不计入统计
+			return false
 		}
-		fmt.Printf("请求失败: %v\n", err)
-		w.stats.RecordError()
-		return
+		class := classifyError(err, trace)
+		w.errorLog.log(class, "%v", err)
+		stats.RecordError(class)
+		return false
+	}
+	bodyStart := time.Now()
+	var body []byte
+	if w.fastPath {
+		var buf *bytes.Buffer
+		buf, err = readBody(resp.Body)
+		defer putBuffer(buf)
+		body = buf.Bytes()
+	} else {
+		body, err = io.ReadAll(resp.Body)
 	}
-	body, err := io.ReadAll(resp.Body)
 	resp.Body.Close()
-	_ = body
+	trace.closeRetired()
+	bodyDone := time.Now()
+	reusable = err == nil && resp.ProtoMajor == 1
+	stats.RecordStatus(resp.StatusCode)
+	stats.RecordProtocol(responseProtocol(resp))
+	if err != nil {
+		class := classifyBodyError(err)
+		w.errorLog.log(class, "读取响应体失败: %v", err)
+		stats.RecordError(class)
+		return false
+	}
 
 	// 检查响应状态码
-	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("请求返回非200状态码: %d, 请求体: %s\n", resp.StatusCode, string(jsonBody))
-		w.stats.RecordError()
-		return
+	if !w.successStatus.Contains(resp.StatusCode) {
+		w.errorLog.log(ErrorHTTPStatus, "状态码 %d, %s %s, 请求体: %s", resp.StatusCode, spec.Method, spec.URL, string(spec.Body))
+		stats.RecordError(ErrorHTTPStatus)
+		return false
 	}
 
-	// 更新基本请求计数
-	atomic.AddInt64(&w.stats.TotalRequests, 1)
-	atomic.AddInt64(&w.stats.TotalBytes, resp.ContentLength)
-
-	// 计算延迟
-	latency := time.Since(start)
-
-	// 更新延迟统计
-	for {
-		oldMin := w.stats.MinLatency
-		if latency >= oldMin {
-			break
-		}
-		if atomic.CompareAndSwapInt64((*int64)(&w.stats.MinLatency), int64(oldMin), int64(latency)) {
-			break
+	// 检查断言，只记录第一条失败的断言
+	for i, a := range w.assertions {
+		if err := a.Check(resp, body); err != nil {
+			w.errorLog.log(ErrorAssertion, "%s: %v", a.Expr, err)
+			stats.RecordAssertionFailure(i)
+			return false
 		}
 	}
 
-	for {
-		oldMax := w.stats.MaxLatency
-		if latency <= oldMax {
-			break
-		}
-		if atomic.CompareAndSwapInt64((*int64)(&w.stats.MaxLatency), int64(oldMax), int64(latency)) {
-			break
+	// 提取变量，任意一个变量提取失败时本次请求计为失败
+	for _, e := range ep.Extract {
+		value, err := e.Extract(resp, body)
+		if err != nil {
+			w.errorLog.log(ErrorExtract, "%s %s: %v", e.Var, e.Expr, err)
+			stats.RecordError(ErrorExtract)
+			return false
 		}
+		s.vars[e.Var] = value
 	}
 
-	atomic.AddInt64((*int64)(&w.stats.TotalLatency), int64(latency))
+	// 计算服务时间和从计划发送时间起的响应时间
+	end := time.Now()
+	latency := end.Sub(start)
+	responseTime := end.Sub(intended)
 
-	// 记录延迟到统计收集器
-	w.statsCollector.RecordLatency(latency)
+	// 更新请求计数、延迟统计和延迟直方图
+	stats.RecordSuccess(latency, responseTime, resp.ContentLength)
+	trace.record(stats, bodyStart, bodyDone)
+	return true
 }
 
 func (w *Worker) worker() {
 	defer w.wg.Done()
 
+	// 每个工作协程是一个虚拟用户，变量和Cookie在多次迭代之间保留
+	s := w.newSession()
+	defer s.close()
 	for {
 		select {
 		case <-w.stopChan:
 			return
 		default:
-			w.makeRequest()
+			// 并发模式为闭环压测，计划发送时间即为当前时间
+			w.makeRequest(time.Now(), s)
 		}
 	}
 }
@@ -283,92 +407,140 @@ func (w *Worker) worker() {
 func (w *Worker) qpsWorker() {
 	defer w.wg.Done()
 
-	// 计算每个10ms间隔需要发送的请求数
-	intervalCount := 50 // 1秒分成100个10ms的间隔
-	baseRequests := w.qps / intervalCount
-	remainder := w.qps % intervalCount
-	tickerInterval := 1000 * time.Millisecond / time.Duration(intervalCount)
-
-	// 预计算每个间隔的请求数
-	requestsPerInterval := make([]int, intervalCount)
-	for i := 0; i < intervalCount; i++ {
-		requestsPerInterval[i] = baseRequests
-		if i < remainder {
-			requestsPerInterval[i]++
-		}
+	// 创建请求发送通道，传递每个请求的计划发送时间
+	channelSize := w.profile.maxRate() * 2 // 增加通道大小
+	if batch := w.arrival.maxBatch(w.profile.maxRate()); batch > channelSize {
+		channelSize = batch
 	}
-
-	// 创建请求发送通道
-	requestChan := make(chan struct{}, w.qps*2) // 增加通道大小
+	requestChan := make(chan time.Time, channelSize)
 
 	// 启动请求发送器
+	health := w.stats.Client
+	health.RateDriven = true
+	health.PoolSize = int64(w.maxWorkers)
 	activeWorkers := int32(0)
+	var senders sync.WaitGroup
+	// 发送器都退出后，通道中剩下的请求不会再发出。停止时已经排队超过 lateTickThreshold 的请求是客户端积压的，
+	// 计为在客户端丢弃；刚交给通道、还没来得及被取走的请求与停止同时发生，不计入
+	defer func() {
+		stopped := time.Now()
+		senders.Wait()
+		abandoned := 0
+		for len(requestChan) > 0 {
+			if intended := <-requestChan; stopped.Sub(intended) > lateTickThreshold {
+				abandoned++
+			}
+		}
+		health.recordAbandoned(abandoned)
+	}()
 	for i := 0; i < int(w.maxWorkers); i++ {
-		w.wg.Add(1)
+		senders.Add(1)
 		go func() {
-			defer w.wg.Done()
+			defer senders.Done()
 			atomic.AddInt32(&activeWorkers, 1)
 			defer atomic.AddInt32(&activeWorkers, -1)
 
+			// 每个发送协程是一个虚拟用户，变量和Cookie在多次迭代之间保留
+			s := w.newSession()
+			defer s.close()
+
 			for {
 				select {
 				case <-w.stopChan:
 					return
-				case <-requestChan:
-					w.makeRequest()
+				case intended := <-requestChan:
+					health.senderBusy()
+					w.makeRequest(intended, s)
+					health.senderIdle()
 				}
 			}
 		}()
 	}
 
-	// 使用10ms的ticker
-	ticker := time.NewTicker(tickerInterval)
-	defer ticker.Stop()
-
-	// 当前间隔的索引
-	intervalIndex := 0
-
 	// 等待所有worker启动
 	time.Sleep(100 * time.Millisecond)
 	fmt.Printf("Active workers: %d\n", atomic.LoadInt32(&activeWorkers))
 
+	// 按负载曲线和到达过程依次计算每批请求的计划发送时间，等到该时间再交给发送器
+	scheduler := newArrivalScheduler(w.profile, w.arrival)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	start := time.Now()
+
 	for {
-		select {
-		case <-w.stopChan:
+		offset, count, rate, ok := scheduler.next()
+		if !ok {
+			// 负载曲线已结束，等待压测停止
+			<-w.stopChan
 			fmt.Println("Stopping QPS worker...")
 			return
-		case <-ticker.C:
-			// 获取当前间隔需要发送的请求数
-			requestsToSend := requestsPerInterval[intervalIndex]
-
-			// 更新间隔索引
-			intervalIndex = (intervalIndex + 1) % intervalCount
+		}
 
-			// 发送请求
-			for i := 0; i < requestsToSend; i++ {
-				select {
-				case requestChan <- struct{}{}:
-					// 请求已发送到通道
-				case <-w.stopChan:
-					fmt.Println("Stopping QPS worker...")
-					return
-				default:
-					// 通道已满，跳过这个请求
-					fmt.Printf("Channel is full (len: %d), skip this request\n", len(requestChan))
-					w.stats.RecordError()
+		intended := start.Add(offset)
+		if wait := time.Until(intended); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-w.stopChan:
+				fmt.Println("Stopping QPS worker...")
+				return
+			case <-timer.C:
+			}
+		}
+		health.recordTick(intended)
+
+		atomic.StoreInt64(&w.stats.TargetQPS, int64(rate+0.5))
+		atomic.AddInt64(&w.stats.ScheduledRequests, int64(count))
+
+		// 发送请求
+		for i := 0; i < count; i++ {
+			select {
+			case requestChan <- intended:
+				// 请求已发送到通道
+				health.recordQueued()
+			case <-w.stopChan:
+				fmt.Println("Stopping QPS worker...")
+				return
+			default:
+				// 通道已满，客户端跟不上目标QPS，跳过这个请求。这是客户端的问题，不计为失败请求
+				if health.recordDropped() {
+					fmt.Printf("警告：发送通道已满（长度 %d），客户端跟不上目标QPS，开始丢弃请求\n", len(requestChan))
 				}
 			}
 		}
 	}
 }
 
-func (w *Worker) Start() {
+// Start 执行压测，直到达到压测时长或者 ctx 被取消。ctx 被取消时停止发送新的请求，
+// 等待正在发送的请求完成，超过宽限期后取消这些请求，统计结果只包含从开始到取消之间的时间。
+func (w *Worker) Start(ctx context.Context) {
+	reqCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+	w.reqCtx = reqCtx
+
+	// 创建HTTP客户端，不设置全局超时，使用 context 控制单个请求超时
+	w.client = w.newClient(w.createRoundTripper())
+
+	// 设置了多步骤流程时依次发送各步骤，否则按权重选择接口，未设置多个接口时使用命令行指定的单个接口
+	if len(w.flow) > 0 {
+		w.steps = newSteps(w.flow, w.stats)
+	} else {
+		endpoints := w.endpoints
+		if len(endpoints) == 0 {
+			endpoints = []Endpoint{{Name: "default", Weight: 1, Method: w.method, URL: w.url, Headers: w.headers, Generator: w.generator}}
+		}
+		w.picker = newEndpointPicker(endpoints, w.stats)
+	}
+	if w.fastPath {
+		prepareTemplates(w.GetEndpointStats())
+		prepareTemplates(w.steps)
+	}
+
 	// 启动统计收集器
 	w.statsCollector.Start()
 
 	// 启动工作协程
-	if w.qps > 0 {
-		// QPS模式：使用一个goroutine，通过ticker控制请求频率
+	if w.profile != nil {
+		// QPS模式：使用一个goroutine，按负载曲线和到达过程控制请求频率
 		w.wg.Add(1)
 		go w.qpsWorker()
 	} else {
@@ -380,25 +552,149 @@ func (w *Worker) Start() {
 	}
 
 	// 设置测试时间
-	time.AfterFunc(w.duration, func() {
-		close(w.stopChan)
-	})
+	w.stats.StartTime = time.Now()
+	w.stats.Duration = w.duration
+	w.stats.Client.start()
+	timer := time.AfterFunc(w.duration, w.stop)
+	defer timer.Stop()
 
 	// 等待所有工作协程完成
-	w.wg.Wait()
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if elapsed := time.Since(w.stats.StartTime); elapsed < w.duration {
+			w.stats.Duration = elapsed
+			w.stats.Interrupted = true
+		}
+		w.stop()
+		grace := time.NewTimer(w.gracePeriod)
+		select {
+		case <-done:
+		case <-grace.C:
+			abort()
+			<-done
+		}
+		grace.Stop()
+	}
+	w.stats.EndTime = time.Now()
+	w.stats.Client.finish(w.stats.EndTime.Sub(w.stats.StartTime), atomic.LoadInt64(&w.stats.ScheduledRequests))
 
 	// 计算每秒请求数
-	w.stats.RequestsPerSec = float64(w.stats.TotalRequests) / w.duration.Seconds()
+	w.stats.RequestsPerSec = float64(w.stats.TotalRequests) / w.stats.Duration.Seconds()
+	finishEndpoints(w.GetEndpointStats(), w.stats.StartTime, w.stats.EndTime, w.stats.Duration)
+	finishEndpoints(w.steps, w.stats.StartTime, w.stats.EndTime, w.stats.Duration)
 
 	// 停止统计收集器
 	w.statsCollector.Stop()
 }
 
+// stop 通知所有工作协程停止发送新的请求
+func (w *Worker) stop() {
+	w.stopOnce.Do(func() { close(w.stopChan) })
+}
+
 // SetMaxWorkers 设置最大工作协程数
 func (w *Worker) SetMaxWorkers(maxWorkers int32) {
 	w.maxWorkers = maxWorkers
 }
 
+// SetStages 设置分阶段的负载曲线，压测时长变为所有阶段的总时长
+func (w *Worker) SetStages(stages []Stage, mode StageMode) {
+	w.profile = &loadProfile{stages: stages, mode: mode}
+	w.duration = StagesDuration(stages)
+}
+
+// SetHeaders 设置额外的HTTP头部，代替 NewWorker 中按字符串解析的头部
+func (w *Worker) SetHeaders(headers map[string]string) {
+	w.headers = headers
+}
+
+// SetSuccessStatus 设置视为成功的HTTP状态码
+func (w *Worker) SetSuccessStatus(status StatusSet) {
+	w.successStatus = status
+}
+
+// SetAssertions 设置对每个响应检查的断言，断言失败的请求计为失败请求
+func (w *Worker) SetAssertions(assertions []*Assertion) {
+	w.assertions = assertions
+	exprs := make([]string, len(assertions))
+	for i, a := range assertions {
+		exprs[i] = a.Expr
+	}
+	w.stats.initAssertions(exprs)
+}
+
+// SetEndpoints 设置多个按权重随机选择的接口，代替 NewWorker 中指定的单个接口。
+// 每个接口的权重必须大于0，设置了多个接口时会分别统计每个接口的数据。
+func (w *Worker) SetEndpoints(endpoints []Endpoint) {
+	w.endpoints = endpoints
+}
+
+// SetFlow 设置多步骤流程，每次迭代依次发送所有步骤，某一步失败时结束本次迭代。
+// 每个工作协程是一个虚拟用户，步骤从响应中提取的变量可以在同一虚拟用户之后的请求中引用。
+// QPS模式下的QPS为每秒开始的迭代数。
+func (w *Worker) SetFlow(steps []Endpoint) {
+	w.flow = steps
+}
+
+// SetSession 设置虚拟用户的Cookie和连接池，默认不保存Cookie、所有虚拟用户共用一个连接池
+func (w *Worker) SetSession(session SessionConfig) {
+	w.session = session
+}
+
+// SetProtocol 设置HTTP协议和HTTP/2的连接数、每个连接上的最大并发流数，默认只使用HTTP/1.1
+func (w *Worker) SetProtocol(protocol ProtocolConfig) {
+	w.protocol = protocol
+}
+
+// SetTLSConfig 设置HTTPS请求的TLS配置，配置中设置了会话缓存时每个连接池使用自己的会话缓存
+func (w *Worker) SetTLSConfig(cfg *tls.Config) {
+	w.tlsConfig = cfg
+}
+
+// SetConnReuse 设置连接复用方式，默认为长连接
+func (w *Worker) SetConnReuse(reuse ConnReuseConfig) {
+	w.connReuse = reuse
+}
+
+// SetFastPath 设置是否在请求固定时预先构造请求并复用读取响应体的缓冲区，默认启用
+func (w *Worker) SetFastPath(enabled bool) {
+	w.fastPath = enabled
+}
+
+// SetGracePeriod 设置压测被中断后等待正在发送的请求完成的最长时间
+func (w *Worker) SetGracePeriod(grace time.Duration) {
+	w.gracePeriod = grace
+}
+
+// SetArrival 设置QPS模式下请求的到达过程
+func (w *Worker) SetArrival(arrival ArrivalConfig) {
+	w.arrival = arrival
+}
+
+// GetSecondStats 获取每秒统计信息的序列，需要在 Start 返回之后调用
+func (w *Worker) GetSecondStats() []*SecondStats {
+	return w.statsCollector.Series()
+}
+
+// GetEndpointStats 获取各接口的统计信息，需要在 Start 返回之后调用
+func (w *Worker) GetEndpointStats() []*EndpointStats {
+	if w.picker == nil {
+		return nil
+	}
+	return w.picker.endpoints
+}
+
+// GetStepStats 获取多步骤流程中各步骤的统计信息，需要在 Start 返回之后调用
+func (w *Worker) GetStepStats() []*EndpointStats {
+	return w.steps
+}
+
 // GetStats 获取统计信息
 func (w *Worker) GetStats() *RequestStats {
 	return w.stats
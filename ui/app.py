@@ -344,10 +344,14 @@ def stop_test():
             if monitoring_thread:
                 monitoring_thread.join(timeout=1)
             
-            # 终止进程
+            # 终止进程，wrkx 收到 SIGTERM 后等待正在发送的请求完成（默认最多5秒）并输出结果
             current_process.terminate()
-            # 等待进程结束
-            current_process.wait(timeout=5)
+            # 等待进程结束，超时则强制结束
+            try:
+                current_process.wait(timeout=15)
+            except subprocess.TimeoutExpired:
+                current_process.kill()
+                current_process.wait()
             
             current_process = None
             monitoring_thread = None